@@ -1,14 +1,24 @@
 package analytics
 
 import (
+	"context"
 	"encoding/json"
+	"expvar"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"fourinrow/config"
+
 	"github.com/IBM/sarama"
 )
 
 type GameEvent struct {
+	// Stable across redeliveries, so consumers can drop duplicates. Only
+	// events sent through the outbox have one.
+	EventID   string      `json:"event_id,omitempty"`
 	Type      string      `json:"type"`
 	GameID    string      `json:"game_id"`
 	PlayerID  string      `json:"player_id"`
@@ -17,32 +27,138 @@ type GameEvent struct {
 }
 
 type ProducerInterface interface {
+	// Emit queues the event and returns without waiting for Kafka
 	Emit(event GameEvent)
-	Close()
+	// Close delivers what is still queued, giving up when ctx expires
+	Close(ctx context.Context) error
+}
+
+// Batching: a batch goes out when it reaches batchMessages or has waited batchLinger
+const (
+	batchMessages = 100
+	batchLinger   = 100 * time.Millisecond
+)
+
+// ProducerStats are counters exported on /debug/vars as "analytics"
+type ProducerStats struct {
+	Emitted   uint64 `json:"emitted"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"` // buffer full, or still queued at Close
+	Buffered  int    `json:"buffered"`
+}
+
+var counters struct {
+	emitted, delivered, failed, dropped atomic.Uint64
+}
+
+func init() {
+	expvar.Publish("analytics", expvar.Func(func() any { return Stats(Producer) }))
+}
+
+func Stats(p ProducerInterface) ProducerStats {
+	st := ProducerStats{
+		Emitted:   counters.emitted.Load(),
+		Delivered: counters.delivered.Load(),
+		Failed:    counters.failed.Load(),
+		Dropped:   counters.dropped.Load(),
+	}
+	if k, ok := p.(*KafkaProducer); ok {
+		st.Buffered = len(k.events)
+	}
+	return st
+}
+
+// drop counts an event that will never be sent, logging now and then so an
+// outage doesn't flood the log
+func drop(reason string) {
+	if n := counters.dropped.Add(1); n == 1 || n%1000 == 0 {
+		log.Printf("[ANALYTICS] ⚠️ Dropped an event (%s), %d so far", reason, n)
+	}
 }
 
 // ---------------------------------------------------------
 // 1. KAFKA PRODUCER (The Real Deal)
 // ---------------------------------------------------------
+
+// KafkaProducer sends events in the background. Emit puts them in a bounded
+// buffer; one goroutine feeds sarama's async producer, which batches them.
 type KafkaProducer struct {
-	producer sarama.SyncProducer
+	producer sarama.AsyncProducer
 	topic    string
+	block    bool                   // wait for room rather than drop when full
+	onError  func(GameEvent, error) // called for each event Kafka rejected
+
+	events  chan GameEvent
+	mu      sync.RWMutex // held by Emit while sending on events, so Close can close it
+	closed  bool
+	closing chan struct{} // wakes blocked Emits
+	abort   chan struct{} // the Close deadline passed; stop feeding Kafka
+	fed     chan struct{} // the feed goroutine finished
+	results sync.WaitGroup
+}
+
+// ProducerOptions tune a KafkaProducer
+type ProducerOptions struct {
+	Buffer int  // events held while Kafka catches up
+	Block  bool // when the buffer is full, Emit waits instead of dropping
+	// OnError is told about every event that could not be delivered;
+	// the default logs it
+	OnError func(event GameEvent, err error)
 }
 
-func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
+func NewKafkaProducer(brokers []string, topic string, opts ProducerOptions) *KafkaProducer {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 5
+	config.Producer.Flush.Messages = batchMessages
+	config.Producer.Flush.Frequency = batchLinger
 
-	p, err := sarama.NewSyncProducer(brokers, config)
+	p, err := sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
 		log.Printf("[ANALYTICS] ⚠️ Failed to start Kafka producer: %v (Using Stub instead)", err)
 		return nil
 	}
 
 	log.Println("[ANALYTICS] ✅ Connected to Kafka!")
-	return &KafkaProducer{producer: p, topic: topic}
+	return newKafkaProducer(p, topic, opts)
+}
+
+func newKafkaProducer(p sarama.AsyncProducer, topic string, opts ProducerOptions) *KafkaProducer {
+	k := &KafkaProducer{
+		producer: p,
+		topic:    topic,
+		block:    opts.Block,
+		onError:  opts.OnError,
+		events:   make(chan GameEvent, max(opts.Buffer, 1)),
+		closing:  make(chan struct{}),
+		abort:    make(chan struct{}),
+		fed:      make(chan struct{}),
+	}
+	if k.onError == nil {
+		k.onError = func(event GameEvent, err error) {
+			log.Printf("[ANALYTICS] Failed to deliver %s for game %s: %v", event.Type, event.GameID, err)
+		}
+	}
+	go k.feed()
+	k.results.Add(2)
+	go func() {
+		defer k.results.Done()
+		for range p.Successes() {
+			counters.delivered.Add(1)
+		}
+	}()
+	go func() {
+		defer k.results.Done()
+		for err := range p.Errors() {
+			counters.failed.Add(1)
+			event, _ := err.Msg.Metadata.(GameEvent)
+			k.onError(event, err.Err)
+		}
+	}()
+	return k
 }
 
 func (k *KafkaProducer) Emit(event GameEvent) {
@@ -51,28 +167,87 @@ func (k *KafkaProducer) Emit(event GameEvent) {
 		event.Timestamp = time.Now().Unix()
 	}
 
-	// Convert event to JSON bytes
-	val, err := json.Marshal(event)
-	if err != nil {
-		log.Printf("[ANALYTICS] JSON Error: %v", err)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		drop("producer closed")
 		return
 	}
+	counters.emitted.Add(1)
 
-	// Send message
-	msg := &sarama.ProducerMessage{
-		Topic: k.topic,
-		Key:   sarama.StringEncoder(event.GameID), // Ensure events for same game go to same partition
-		Value: sarama.ByteEncoder(val),
+	if k.block {
+		select {
+		case k.events <- event:
+		case <-k.closing:
+			drop("producer closed")
+		}
+		return
+	}
+	select {
+	case k.events <- event:
+	default:
+		drop("buffer full")
 	}
+}
 
-	_, _, err = k.producer.SendMessage(msg)
-	if err != nil {
-		log.Printf("[ANALYTICS] Failed to send message: %v", err)
+// feed hands buffered events to sarama until the buffer is closed and empty
+func (k *KafkaProducer) feed() {
+	defer close(k.fed)
+	for event := range k.events {
+		// Convert event to JSON bytes
+		val, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("[ANALYTICS] JSON Error: %v", err)
+			continue
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic:    k.topic,
+			Key:      sarama.StringEncoder(event.GameID), // Ensure events for same game go to same partition
+			Value:    sarama.ByteEncoder(val),
+			Metadata: event,
+		}
+		select {
+		case k.producer.Input() <- msg:
+		case <-k.abort:
+			drop("close deadline")
+			for range k.events {
+				drop("close deadline")
+			}
+			return
+		}
 	}
 }
 
-func (k *KafkaProducer) Close() {
-	k.producer.Close()
+// Close stops taking events, then waits for the buffer and sarama's batches to
+// drain. Whatever is still queued when ctx expires is dropped.
+func (k *KafkaProducer) Close(ctx context.Context) error {
+	close(k.closing)
+	k.mu.Lock()
+	k.closed = true
+	close(k.events)
+	k.mu.Unlock()
+
+	select {
+	case <-k.fed:
+	case <-ctx.Done():
+		close(k.abort)
+		<-k.fed
+	}
+
+	// sarama flushes its batches and then closes Successes and Errors
+	k.producer.AsyncClose()
+	flushed := make(chan struct{})
+	go func() {
+		k.results.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("analytics: gave up flushing: %w", ctx.Err())
+	}
 }
 
 // ---------------------------------------------------------
@@ -82,9 +257,28 @@ type StubProducer struct{}
 
 func NewStubProducer() *StubProducer { return &StubProducer{} }
 func (s *StubProducer) Emit(event GameEvent) {
+	counters.emitted.Add(1)
 	log.Printf("[ANALYTICS STUB] %+v\n", event)
 }
-func (s *StubProducer) Close() {}
+func (s *StubProducer) Close(ctx context.Context) error { return nil }
+
+// HasKafka reports whether p publishes to Kafka rather than to the log
+func HasKafka(p ProducerInterface) bool {
+	_, ok := p.(*KafkaProducer)
+	return ok
+}
 
 // Global Instance
-var Producer ProducerInterface
\ No newline at end of file
+var Producer ProducerInterface
+
+// Open connects to the configured Kafka brokers, falling back to the stub
+// when they can't be reached
+func Open(cfg config.Config) ProducerInterface {
+	// NewKafkaProducer returns a typed nil on failure, which must not end up
+	// inside the interface
+	opts := ProducerOptions{Buffer: cfg.AnalyticsBuffer, Block: cfg.AnalyticsOverflow == config.OverflowBlock}
+	if p := NewKafkaProducer(cfg.KafkaBrokers, cfg.KafkaTopic, opts); p != nil {
+		return p
+	}
+	return NewStubProducer()
+}
@@ -0,0 +1,115 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeKafka is an AsyncProducer that takes nothing from Input until released
+type fakeKafka struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newFakeKafka() *fakeKafka {
+	return &fakeKafka{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (f *fakeKafka) Input() chan<- *sarama.ProducerMessage     { return f.input }
+func (f *fakeKafka) Successes() <-chan *sarama.ProducerMessage { return f.successes }
+func (f *fakeKafka) Errors() <-chan *sarama.ProducerError      { return f.errors }
+func (f *fakeKafka) AsyncClose()                               { close(f.input) }
+
+// release acknowledges every message from now on
+func (f *fakeKafka) release() {
+	go func() {
+		for msg := range f.input {
+			f.successes <- msg
+		}
+		close(f.successes)
+		close(f.errors)
+	}()
+}
+
+// stalled returns a producer whose feed goroutine is stuck handing one event
+// to Kafka, so the buffer holds exactly what is emitted next
+func stalled(t *testing.T, opts ProducerOptions) (*KafkaProducer, *fakeKafka) {
+	t.Helper()
+	fake := newFakeKafka()
+	k := newKafkaProducer(fake, "game-events", opts)
+	k.Emit(GameEvent{Type: "game_started", GameID: "g0"})
+	for deadline := time.Now().Add(time.Second); len(k.events) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the feed goroutine never took the first event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return k, fake
+}
+
+func TestEmitDropsWhenBufferFull(t *testing.T) {
+	k, fake := stalled(t, ProducerOptions{Buffer: 2})
+	before := Stats(k)
+
+	for _, id := range []string{"g1", "g2", "g3"} {
+		k.Emit(GameEvent{Type: "move", GameID: id})
+	}
+	st := Stats(k)
+	if st.Buffered != 2 || st.Dropped-before.Dropped != 1 || st.Emitted-before.Emitted != 3 {
+		t.Fatalf("after overfilling: %+v (before %+v), want 2 buffered and 1 dropped", st, before)
+	}
+
+	// What was buffered still goes out on Close
+	fake.release()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := k.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := Stats(k).Delivered - before.Delivered; got != 3 {
+		t.Errorf("delivered %d events, want 3", got)
+	}
+}
+
+func TestEmitBlocksWhenBufferFull(t *testing.T) {
+	k, fake := stalled(t, ProducerOptions{Buffer: 1, Block: true})
+	before := Stats(k)
+
+	k.Emit(GameEvent{Type: "move", GameID: "g1"})
+	emitted := make(chan struct{})
+	go func() {
+		k.Emit(GameEvent{Type: "move", GameID: "g2"})
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+		t.Fatal("Emit returned with the buffer full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once Kafka takes events again the waiting Emit gets through
+	fake.release()
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("Emit still blocked after Kafka caught up")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := k.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	st := Stats(k)
+	if st.Dropped != before.Dropped || st.Delivered-before.Delivered != 3 {
+		t.Errorf("after Close: %+v (before %+v), want 3 delivered and none dropped", st, before)
+	}
+}
@@ -0,0 +1,156 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Outbox holds events saved in the same transaction as the data they
+// describe until the relay has published them. db.Repository implements it.
+type Outbox interface {
+	// ClaimEvents returns up to limit unpublished events, oldest first, that
+	// no other relay holds, and holds them for lease. Every instance runs a
+	// relay, so this is what keeps them from publishing the same rows.
+	ClaimEvents(limit int, lease time.Duration) ([]GameEvent, error)
+	// MarkEventsSent records that Kafka acknowledged the events
+	MarkEventsSent(ids []string) error
+	// MarkEventsFailed records a failed attempt to publish the events and
+	// releases them for the next try
+	MarkEventsFailed(ids []string, reason string) error
+}
+
+// Publisher sends a batch of events, returning once Kafka has all of them
+type Publisher interface {
+	Publish(events []GameEvent) error
+	Close() error
+}
+
+// Relay tuning
+const (
+	relayBatch      = 100
+	relayInterval   = time.Second // how often an idle relay looks for new events
+	relayMaxBackoff = time.Minute
+	// How long a claimed batch is ours. A relay that dies mid-batch leaves
+	// it to another instance after this; one that is merely slow may see
+	// the batch sent twice, which at-least-once delivery allows.
+	relayLease = 2 * time.Minute
+)
+
+// Relay moves events from the outbox to Kafka. An event is marked sent only
+// after Kafka acknowledged it, so every event is delivered at least once;
+// a crash between the two sends it again with the same EventID.
+type Relay struct {
+	outbox Outbox
+	pub    Publisher
+}
+
+func NewRelay(outbox Outbox, pub Publisher) *Relay {
+	return &Relay{outbox: outbox, pub: pub}
+}
+
+// Run publishes pending events until ctx is done, backing off while Kafka or
+// the database is failing. Anything still pending goes out on the next start.
+func (r *Relay) Run(ctx context.Context) {
+	defer r.pub.Close()
+
+	backoff := relayInterval
+	for {
+		n, err := r.publishBatch()
+		wait := relayInterval
+		switch {
+		case err != nil:
+			log.Printf("[OUTBOX] ⚠️ Publishing failed, retrying in %s: %v", backoff, err)
+			wait = backoff
+			backoff = min(backoff*2, relayMaxBackoff)
+		case n == relayBatch:
+			wait, backoff = 0, relayInterval // more are waiting
+		default:
+			backoff = relayInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// publishBatch sends the oldest pending events, returning how many went out
+func (r *Relay) publishBatch() (int, error) {
+	events, err := r.outbox.ClaimEvents(relayBatch, relayLease)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.EventID
+	}
+
+	if err := r.pub.Publish(events); err != nil {
+		if markErr := r.outbox.MarkEventsFailed(ids, err.Error()); markErr != nil {
+			log.Printf("[OUTBOX] Failed to record attempt: %v", markErr)
+		}
+		return 0, err
+	}
+	return len(events), r.outbox.MarkEventsSent(ids)
+}
+
+// KafkaPublisher publishes with a SyncProducer. It connects on first use and
+// reconnects after a failure, so the relay can start before Kafka does. It
+// is meant for one goroutine, the relay.
+type KafkaPublisher struct {
+	brokers  []string
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{brokers: brokers, topic: topic}
+}
+
+func (k *KafkaPublisher) Publish(events []GameEvent) error {
+	if k.producer == nil {
+		config := sarama.NewConfig()
+		config.Producer.Return.Successes = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Retry.Max = 5
+		p, err := sarama.NewSyncProducer(k.brokers, config)
+		if err != nil {
+			return err
+		}
+		k.producer = p
+	}
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, event := range events {
+		val, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:   k.topic,
+			Key:     sarama.StringEncoder(event.GameID),
+			Value:   sarama.ByteEncoder(val),
+			Headers: []sarama.RecordHeader{{Key: []byte("event_id"), Value: []byte(event.EventID)}},
+		})
+	}
+	if err := k.producer.SendMessages(msgs); err != nil {
+		k.Close()
+		return err
+	}
+	return nil
+}
+
+func (k *KafkaPublisher) Close() error {
+	if k.producer == nil {
+		return nil
+	}
+	err := k.producer.Close()
+	k.producer = nil
+	return err
+}
@@ -0,0 +1,76 @@
+// Package cluster lets several server instances act as one: nodes find each
+// other through heartbeats on a shared pub/sub broker, agree on a leader and
+// send each other addressed messages.
+package cluster
+
+import (
+	"context"
+	"sync"
+)
+
+// Broker is the pub/sub transport between nodes. Delivery is at most once;
+// messages from one publisher on one topic arrive in order.
+type Broker interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+	// Subscribe calls handler for every message on topic until unsubscribe
+	// is called. Handlers for one subscription never run concurrently.
+	Subscribe(topic string, handler func([]byte)) (unsubscribe func(), err error)
+}
+
+// subscriberBuffer is how many messages a slow subscriber may fall behind
+// before further ones are dropped
+const subscriberBuffer = 256
+
+// LocalBroker is an in-process Broker, for running several nodes inside one
+// process (tests, local experiments) without Redis
+type LocalBroker struct {
+	mu     sync.Mutex
+	topics map[string]map[*localSub]bool
+}
+
+type localSub struct {
+	ch   chan []byte
+	once sync.Once
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{topics: make(map[string]map[*localSub]bool)}
+}
+
+func (b *LocalBroker) Publish(ctx context.Context, topic string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.topics[topic] {
+		msg := append([]byte(nil), data...)
+		select {
+		case sub.ch <- msg:
+		default: // like Redis, a subscriber that can't keep up loses messages
+		}
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	sub := &localSub{ch: make(chan []byte, subscriberBuffer)}
+
+	b.mu.Lock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*localSub]bool)
+	}
+	b.topics[topic][sub] = true
+	b.mu.Unlock()
+
+	go func() {
+		for msg := range sub.ch {
+			handler(msg)
+		}
+	}()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.topics[topic], sub)
+		b.mu.Unlock()
+		sub.once.Do(func() { close(sub.ch) })
+	}, nil
+}
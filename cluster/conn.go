@@ -0,0 +1,27 @@
+package cluster
+
+import "encoding/json"
+
+// KindDeliver carries a message to be written to a player's websocket on
+// their home node
+const KindDeliver = "deliver"
+
+// RemoteConn writes to a player whose websocket lives on another node. It is
+// a comparable value, so two RemoteConns for the same player and node are equal.
+type RemoteConn struct {
+	Node     *Node
+	Home     string
+	Username string
+}
+
+func (c RemoteConn) WriteJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Node.Send(c.Home, Envelope{Kind: KindDeliver, Home: c.Home, Username: c.Username, Payload: payload})
+}
+
+// Close is a no-op: the socket belongs to the home node, which closes it
+// when the player leaves
+func (c RemoteConn) Close() error { return nil }
@@ -0,0 +1,324 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HeartbeatInterval = time.Second
+	// A peer missing this many heartbeats in a row is considered gone
+	PeerTimeout = 3 * HeartbeatInterval
+
+	topicPrefix    = "fourinrow:cluster:"
+	heartbeatTopic = topicPrefix + "heartbeat"
+	publishTimeout = 2 * time.Second
+)
+
+// Envelope is one message between nodes. Home is the node holding the
+// player's websocket, so replies can be relayed back to it.
+type Envelope struct {
+	Kind     string          `json:"kind"`
+	From     string          `json:"from"`
+	Home     string          `json:"home,omitempty"`
+	Username string          `json:"username,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// heartbeat is what each node announces every HeartbeatInterval
+type heartbeat struct {
+	ID       string `json:"id"`
+	Draining bool   `json:"draining,omitempty"`
+}
+
+type peer struct {
+	seen     time.Time
+	draining bool
+}
+
+// Handler processes an envelope addressed to this node
+type Handler func(Envelope)
+
+// Node is this process's membership in the cluster
+type Node struct {
+	ID     string
+	broker Broker
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	peers    map[string]peer // excluding ourselves
+	leader   string
+	draining bool
+	onLeader []func(leader string)
+	stop     chan struct{}
+	unsubs   []func()
+}
+
+func NewNode(id string, broker Broker) *Node {
+	return &Node{
+		ID: id, broker: broker,
+		handlers: make(map[string]Handler),
+		peers:    make(map[string]peer),
+		leader:   id,
+	}
+}
+
+func nodeTopic(id string) string { return topicPrefix + "node:" + id }
+
+// Handle registers fn for envelopes of the given kind. Register every
+// handler before Start.
+func (n *Node) Handle(kind string, fn Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[kind] = fn
+}
+
+// OnLeaderChange registers fn to run whenever a different node becomes leader
+func (n *Node) OnLeaderChange(fn func(leader string)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onLeader = append(n.onLeader, fn)
+}
+
+// Start subscribes to this node's inbox and begins heartbeating
+func (n *Node) Start() error {
+	inbox, err := n.broker.Subscribe(nodeTopic(n.ID), n.receive)
+	if err != nil {
+		return err
+	}
+	beats, err := n.broker.Subscribe(heartbeatTopic, n.receiveHeartbeat)
+	if err != nil {
+		inbox()
+		return err
+	}
+
+	n.mu.Lock()
+	n.unsubs = []func(){inbox, beats}
+	n.stop = make(chan struct{})
+	stop := n.stop
+	n.mu.Unlock()
+
+	n.beat()
+	go n.run(stop)
+	log.Printf("[CLUSTER] Node %s started", n.ID)
+	return nil
+}
+
+// Stop leaves the cluster. Peers notice once our heartbeats stop.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stop == nil {
+		return
+	}
+	close(n.stop)
+	n.stop = nil
+	for _, unsub := range n.unsubs {
+		unsub()
+	}
+	n.unsubs = nil
+}
+
+func (n *Node) run(stop chan struct{}) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n.beat()
+			n.expirePeers(time.Now())
+		}
+	}
+}
+
+// SetDraining stops this node from being picked as leader or to host new
+// games, while it keeps serving the games it already has
+func (n *Node) SetDraining(draining bool) {
+	n.mu.Lock()
+	n.draining = draining
+	n.mu.Unlock()
+	n.beat()
+	n.electLeader()
+}
+
+func (n *Node) beat() {
+	n.mu.Lock()
+	data, _ := json.Marshal(heartbeat{ID: n.ID, Draining: n.draining})
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := n.broker.Publish(ctx, heartbeatTopic, data); err != nil {
+		log.Printf("[CLUSTER] Heartbeat failed: %v", err)
+	}
+}
+
+func (n *Node) receiveHeartbeat(data []byte) {
+	var hb heartbeat
+	if err := json.Unmarshal(data, &hb); err != nil || hb.ID == n.ID {
+		return
+	}
+	n.mu.Lock()
+	prev, known := n.peers[hb.ID]
+	n.peers[hb.ID] = peer{seen: time.Now(), draining: hb.Draining}
+	n.mu.Unlock()
+
+	if !known {
+		log.Printf("[CLUSTER] Node %s joined", hb.ID)
+	}
+	if !known || prev.draining != hb.Draining {
+		n.electLeader()
+	}
+}
+
+func (n *Node) expirePeers(now time.Time) {
+	n.mu.Lock()
+	var gone []string
+	for id, p := range n.peers {
+		if now.Sub(p.seen) > PeerTimeout {
+			delete(n.peers, id)
+			gone = append(gone, id)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, id := range gone {
+		log.Printf("[CLUSTER] Node %s stopped responding", id)
+	}
+	if len(gone) > 0 {
+		n.electLeader()
+	}
+}
+
+// electLeader picks the live node with the smallest ID that isn't draining.
+// Every node sees the same membership after a heartbeat or two, so they
+// converge on one leader.
+func (n *Node) electLeader() {
+	n.mu.Lock()
+	var leader string
+	for _, id := range n.candidates() {
+		if leader == "" || id < leader {
+			leader = id
+		}
+	}
+	if leader == "" {
+		leader = n.ID // everyone is draining; keep things running here
+	}
+	changed := leader != n.leader
+	n.leader = leader
+	callbacks := append([]func(string){}, n.onLeader...)
+	n.mu.Unlock()
+
+	if changed {
+		log.Printf("[CLUSTER] Leader is now %s", leader)
+		for _, fn := range callbacks {
+			fn(leader)
+		}
+	}
+}
+
+func (n *Node) receive(data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		log.Printf("[CLUSTER] Dropping malformed envelope: %v", err)
+		return
+	}
+	n.mu.Lock()
+	fn := n.handlers[env.Kind]
+	n.mu.Unlock()
+	if fn == nil {
+		log.Printf("[CLUSTER] No handler for %q from %s", env.Kind, env.From)
+		return
+	}
+	fn(env)
+}
+
+// Send delivers env to node to. From is filled in, and Home defaults to us.
+func (n *Node) Send(to string, env Envelope) error {
+	env.From = n.ID
+	if env.Home == "" {
+		env.Home = n.ID
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return n.broker.Publish(ctx, nodeTopic(to), data)
+}
+
+// Leader is the node currently running shared work such as matchmaking
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+func (n *Node) IsLeader() bool { return n.Leader() == n.ID }
+
+// Alive reports whether id is us or a peer we've heard from recently
+func (n *Node) Alive(id string) bool {
+	if id == n.ID {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.peers[id]
+	return ok
+}
+
+// Members lists every live node including this one, sorted
+func (n *Node) Members() []string {
+	n.mu.Lock()
+	ids := []string{n.ID}
+	for id := range n.peers {
+		ids = append(ids, id)
+	}
+	n.mu.Unlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// candidates are the live nodes that may take on new work. Callers hold n.mu.
+func (n *Node) candidates() []string {
+	var ids []string
+	if !n.draining {
+		ids = append(ids, n.ID)
+	}
+	for id, p := range n.peers {
+		if !p.draining {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// PickOwner chooses which live, non-draining node should host key using
+// rendezvous hashing, so load spreads evenly and nodes agree without
+// coordinating
+func (n *Node) PickOwner(key string) string {
+	n.mu.Lock()
+	ids := n.candidates()
+	n.mu.Unlock()
+
+	best := n.ID
+	var bestScore uint64
+	for i, id := range ids {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = id, score
+		}
+	}
+	return best
+}
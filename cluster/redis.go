@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const dialTimeout = 2 * time.Second
+
+// RedisBroker carries cluster traffic over Redis pub/sub
+type RedisBroker struct {
+	rdb redis.UniversalClient
+}
+
+func NewRedisBroker(rdb redis.UniversalClient) *RedisBroker {
+	return &RedisBroker{rdb: rdb}
+}
+
+// DialRedis connects to a redis:// URL and checks the server is reachable
+func DialRedis(url string) (*RedisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return NewRedisBroker(rdb), nil
+}
+
+func (b *RedisBroker) Close() error { return b.rdb.Close() }
+
+func (b *RedisBroker) Publish(ctx context.Context, topic string, data []byte) error {
+	return b.rdb.Publish(ctx, topic, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(topic string, handler func([]byte)) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	ps := b.rdb.Subscribe(ctx, topic)
+	// Wait for the subscription to be confirmed so nothing sent after we
+	// return is missed
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range ps.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return func() { ps.Close() }, nil
+}
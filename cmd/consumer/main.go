@@ -8,10 +8,13 @@ import (
 	"os/signal"
 	"time"
 
+	appconfig "fourinrow/config"
+
 	"github.com/IBM/sarama"
 )
 
 type GameEvent struct {
+	EventID   string      `json:"event_id,omitempty"`
 	Type      string      `json:"type"`
 	GameID    string      `json:"game_id"`
 	PlayerID  string      `json:"player_id"`
@@ -27,9 +30,40 @@ var (
 	gamesPerHour   = make(map[string]int)
 )
 
+// The outbox relay delivers at least once; remember recent event IDs so a
+// redelivered event isn't counted twice
+const maxSeenEvents = 100000
+
+var (
+	seenEvents = make(map[string]bool)
+	seenOrder  []string
+)
+
+// seen reports whether the event was already processed, and records it
+func seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	if seenEvents[id] {
+		return true
+	}
+	seenEvents[id] = true
+	seenOrder = append(seenOrder, id)
+	if len(seenOrder) > maxSeenEvents {
+		delete(seenEvents, seenOrder[0])
+		seenOrder = seenOrder[1:]
+	}
+	return false
+}
+
 func main() {
-	brokers := []string{"localhost:9092"}
-	topic := "game-events"
+	// Same settings (file, env, flags) as the server, so both agree on Kafka
+	cfg, err := appconfig.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	brokers := cfg.KafkaBrokers
+	topic := cfg.KafkaTopic
 
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
@@ -74,6 +108,10 @@ func processMessage(value []byte) {
 		log.Printf("Invalid JSON: %v", err)
 		return
 	}
+	if seen(event.EventID) {
+		log.Printf("Skipping duplicate event %s", event.EventID)
+		return
+	}
 
 	// 1. Track Games Per Hour
 	t := time.Unix(event.Timestamp, 0)
@@ -0,0 +1,94 @@
+// Command migrate applies or reverts database schema migrations.
+//
+//	migrate up [VERSION]   apply pending migrations (up to VERSION)
+//	migrate down [STEPS]   revert the last STEPS migrations (default 1)
+//	migrate status         list migrations and when they were applied
+//
+// Settings flags (-config, -database-url, ...) follow the command.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"fourinrow/config"
+	"fourinrow/db"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up [VERSION] | down [STEPS] | status  [-config FILE] [-database-url postgres://...|sqlite:PATH]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	// An optional number right after the command
+	n := 0
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil {
+			if v < 0 {
+				usage()
+			}
+			n, args = v, args[1:]
+		}
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+
+	conn, dialect, err := db.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	migrator, err := db.NewMigrator(conn, dialect)
+	if err != nil {
+		log.Fatalf("Bad migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		ran, err := migrator.UpTo(ctx, n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Applied %d migrations\n", ran)
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		ran, err := migrator.Down(ctx, n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Reverted %d migrations\n", ran)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-20s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,37 @@
+// Command rebuildstats recomputes the leaderboard's player_stats table from
+// the saved games. The server keeps the table up to date as games finish;
+// run this after editing games by hand or to repair drift.
+//
+//	rebuildstats [-config FILE] [-database-url postgres://...|sqlite:PATH]
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"fourinrow/config"
+	"fourinrow/db"
+)
+
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+
+	repo, err := db.Open(cfg.DatabaseURL, cfg.ChatLogs)
+	if err != nil {
+		log.Fatalf("Open: %v", err)
+	}
+	defer repo.Close()
+
+	n, err := repo.RebuildPlayerStats()
+	if err != nil {
+		log.Fatalf("Rebuild failed: %v", err)
+	}
+	fmt.Printf("Rebuilt player stats from %d games\n", n)
+}
@@ -0,0 +1,292 @@
+// Package config loads the server's settings. Each setting has a default and
+// can be overridden, in increasing order of precedence, by an optional YAML
+// or TOML file, an environment variable and a command-line flag.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults for settings that used to be hard-coded
+const (
+	DefaultPort               = "5000"
+	DefaultKafkaBroker        = "localhost:9092"
+	DefaultKafkaTopic         = "game-events"
+	DefaultMatchmakingTimeout = 10 * time.Second
+	DefaultSearchWindowTime   = 30 * time.Second
+	DefaultDisconnectTimeout  = 30 * time.Second
+	DefaultBotThinkTime       = 500 * time.Millisecond
+	// Most orchestrators wait 30s after SIGTERM before killing the process
+	DefaultShutdownTimeout = 25 * time.Second
+	DefaultLeaderboardTTL  = 30 * time.Second
+	DefaultAnalyticsBuffer = 10000
+)
+
+// What Emit does when the analytics buffer is full
+const (
+	OverflowDrop  = "drop"  // discard the event so gameplay never waits
+	OverflowBlock = "block" // wait for room, slowing the game down instead
+)
+
+type Config struct {
+	Port          string   `yaml:"port" toml:"port"`
+	KafkaBrokers  []string `yaml:"kafka_brokers" toml:"kafka_brokers"`
+	KafkaTopic    string   `yaml:"kafka_topic" toml:"kafka_topic"`
+	DatabaseURL   string   `yaml:"database_url" toml:"database_url"`
+	SessionSecret string   `yaml:"session_secret" toml:"session_secret"`
+	RedisURL      string   `yaml:"redis_url" toml:"redis_url"`
+	SnapshotDir   string   `yaml:"snapshot_dir" toml:"snapshot_dir"`
+	ClusterNodeID string   `yaml:"cluster_node_id" toml:"cluster_node_id"`
+	ChatLogs      bool     `yaml:"chat_logs" toml:"chat_logs"`
+
+	// Analytics events held in memory while Kafka catches up
+	AnalyticsBuffer int `yaml:"analytics_buffer" toml:"analytics_buffer"`
+	// OverflowDrop or OverflowBlock
+	AnalyticsOverflow string `yaml:"analytics_overflow" toml:"analytics_overflow"`
+
+	// How long a queued player waits for a human before getting a bot
+	MatchmakingTimeout Duration `yaml:"matchmaking_timeout" toml:"matchmaking_timeout"`
+	// How long the rating window takes to widen from its narrowest to its widest
+	SearchWindowTime Duration `yaml:"search_window_time" toml:"search_window_time"`
+	// How long a dropped player has to reconnect before forfeiting
+	DisconnectTimeout Duration `yaml:"disconnect_timeout" toml:"disconnect_timeout"`
+	// Pause before the bot replies, so its moves don't land instantly
+	BotThinkTime Duration `yaml:"bot_think_time" toml:"bot_think_time"`
+	// Deadline for draining games and flushing on SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// How long a leaderboard page is served from cache; 0 turns caching off
+	LeaderboardTTL Duration `yaml:"leaderboard_ttl" toml:"leaderboard_ttl"`
+}
+
+// Duration is a time.Duration written as "30s" or "500ms" in config files
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+// Default is the configuration used when nothing is overridden
+func Default() Config {
+	return Config{
+		Port:               DefaultPort,
+		KafkaBrokers:       []string{DefaultKafkaBroker},
+		KafkaTopic:         DefaultKafkaTopic,
+		MatchmakingTimeout: Duration{DefaultMatchmakingTimeout},
+		SearchWindowTime:   Duration{DefaultSearchWindowTime},
+		DisconnectTimeout:  Duration{DefaultDisconnectTimeout},
+		BotThinkTime:       Duration{DefaultBotThinkTime},
+		ShutdownTimeout:    Duration{DefaultShutdownTimeout},
+		LeaderboardTTL:     Duration{DefaultLeaderboardTTL},
+		AnalyticsBuffer:    DefaultAnalyticsBuffer,
+		AnalyticsOverflow:  OverflowDrop,
+	}
+}
+
+// setting ties one field to its environment variable and flag
+type setting struct {
+	env, flag, usage string
+	value            flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"PORT", "port", "HTTP port to listen on", (*stringValue)(&c.Port)},
+		{"KAFKA_BROKER", "kafka-broker", "comma-separated Kafka brokers for analytics", (*listValue)(&c.KafkaBrokers)},
+		{"KAFKA_TOPIC", "kafka-topic", "Kafka topic for game events", (*stringValue)(&c.KafkaTopic)},
+		{"DATABASE_URL", "database-url", "postgres:// URL, sqlite:PATH or memory: (the default)", (*stringValue)(&c.DatabaseURL)},
+		{"SESSION_SECRET", "session-secret", "key that signs guest cookies (random when empty)", (*stringValue)(&c.SessionSecret)},
+		{"REDIS_URL", "redis-url", "redis:// URL for the shared game store", (*stringValue)(&c.RedisURL)},
+		{"SNAPSHOT_DIR", "snapshot-dir", "directory for in-progress game snapshots", (*stringValue)(&c.SnapshotDir)},
+		{"CLUSTER_NODE_ID", "cluster-node-id", "unique instance name; enables clustering over Redis", (*stringValue)(&c.ClusterNodeID)},
+		{"CHAT_LOGS", "chat-logs", "store in-game chat with saved games", (*boolValue)(&c.ChatLogs)},
+		{"ANALYTICS_BUFFER", "analytics-buffer", "analytics events held while Kafka is slow", (*intValue)(&c.AnalyticsBuffer)},
+		{"ANALYTICS_OVERFLOW", "analytics-overflow", "when the analytics buffer is full: drop or block", (*stringValue)(&c.AnalyticsOverflow)},
+		{"MATCHMAKING_TIMEOUT", "matchmaking-timeout", "wait for a human opponent before offering a bot", (*durationValue)(&c.MatchmakingTimeout)},
+		{"SEARCH_WINDOW_TIME", "search-window-time", "time for the opponent rating window to widen fully", (*durationValue)(&c.SearchWindowTime)},
+		{"DISCONNECT_TIMEOUT", "disconnect-timeout", "time a dropped player has to reconnect", (*durationValue)(&c.DisconnectTimeout)},
+		{"BOT_THINK_TIME", "bot-think-time", "pause before the bot moves", (*durationValue)(&c.BotThinkTime)},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "deadline for a graceful shutdown", (*durationValue)(&c.ShutdownTimeout)},
+		{"LEADERBOARD_TTL", "leaderboard-ttl", "how long leaderboard pages are cached (0 disables)", (*durationValue)(&c.LeaderboardTTL)},
+	}
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and args (usually os.Args[1:]), then validates it
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("fourinrow", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	settings := cfg.settings()
+	for _, s := range settings {
+		fs.Var(s.value, s.flag, s.usage+" (env "+s.env+")")
+	}
+
+	// The file sits below env and flags, so find it before applying either
+	if p := configFlag(args); p != "" {
+		*path = p
+	}
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return cfg, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.value.Set(v); err != nil {
+				return cfg, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// configFlag pulls -config out of args without parsing the rest
+func configFlag(args []string) string {
+	for i, a := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config file %s: want .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid TCP port", c.Port))
+	}
+	if len(c.KafkaBrokers) == 0 {
+		errs = append(errs, errors.New("at least one Kafka broker is required"))
+	}
+	if c.KafkaTopic == "" {
+		errs = append(errs, errors.New("kafka topic is required"))
+	}
+	if c.ClusterNodeID != "" && c.RedisURL == "" {
+		errs = append(errs, errors.New("cluster node id needs a redis url"))
+	}
+	if c.MatchmakingTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("matchmaking timeout must be positive"))
+	}
+	if c.SearchWindowTime.Duration <= 0 {
+		errs = append(errs, errors.New("search window time must be positive"))
+	}
+	if c.DisconnectTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("disconnect timeout must be positive"))
+	}
+	if c.BotThinkTime.Duration < 0 {
+		errs = append(errs, errors.New("bot think time can't be negative"))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if c.AnalyticsBuffer < 1 {
+		errs = append(errs, errors.New("analytics buffer must be at least 1"))
+	}
+	if c.AnalyticsOverflow != OverflowDrop && c.AnalyticsOverflow != OverflowBlock {
+		errs = append(errs, fmt.Errorf("analytics overflow %q: want %s or %s", c.AnalyticsOverflow, OverflowDrop, OverflowBlock))
+	}
+	if c.LeaderboardTTL.Duration < 0 {
+		errs = append(errs, errors.New("leaderboard ttl can't be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// flag.Value adapters that write straight into Config fields
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type boolValue bool
+
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type durationValue Duration
+
+func (v *durationValue) String() string     { return v.Duration.String() }
+func (v *durationValue) Set(s string) error { return (*Duration)(v).UnmarshalText([]byte(s)) }
+
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v = items
+	return nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv blanks every setting's variable for the test; Load ignores empty ones
+func clearEnv(t *testing.T) {
+	t.Helper()
+	var c Config
+	for _, s := range c.settings() {
+		t.Setenv(s.env, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Port != DefaultPort || cfg.MatchmakingTimeout.Duration != DefaultMatchmakingTimeout || cfg.AnalyticsOverflow != OverflowDrop {
+		t.Errorf("defaults = %+v", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	yamlFile := writeFile(t, "fourinrow.yaml", `
+port: "6000"
+kafka_topic: from-file
+matchmaking_timeout: 20s
+bot_think_time: 1s
+chat_logs: true
+`)
+
+	// Each layer overrides the one before it, and only what it sets
+	t.Setenv("KAFKA_TOPIC", "from-env")
+	t.Setenv("MATCHMAKING_TIMEOUT", "15s")
+	cfg, err := Load([]string{"-config", yamlFile, "-matchmaking-timeout=5s", "-kafka-broker", "a:9092, b:9092"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"port (file)", cfg.Port, "6000"},
+		{"chat logs (file)", cfg.ChatLogs, true},
+		{"bot think time (file)", cfg.BotThinkTime.Duration, time.Second},
+		{"kafka topic (env over file)", cfg.KafkaTopic, "from-env"},
+		{"matchmaking timeout (flag over env and file)", cfg.MatchmakingTimeout.Duration, 5 * time.Second},
+		{"kafka brokers (flag)", strings.Join(cfg.KafkaBrokers, ","), "a:9092,b:9092"},
+		{"disconnect timeout (default)", cfg.DisconnectTimeout.Duration, DefaultDisconnectTimeout},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	// TOML works the same, and CONFIG_FILE names the file
+	tomlFile := writeFile(t, "fourinrow.toml", "port = \"7000\"\nleaderboard_ttl = \"0s\"\n")
+	t.Setenv("CONFIG_FILE", tomlFile)
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatalf("Load with CONFIG_FILE: %v", err)
+	}
+	if cfg.Port != "7000" || cfg.LeaderboardTTL.Duration != 0 {
+		t.Errorf("from TOML: port %s, leaderboard ttl %v", cfg.Port, cfg.LeaderboardTTL)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	clearEnv(t)
+	for name, args := range map[string][]string{
+		"unknown file type": {"-config", writeFile(t, "fourinrow.ini", "port=1")},
+		"missing file":      {"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+		"bad yaml":          {"-config", writeFile(t, "bad.yaml", "port: [")},
+		"bad duration flag": {"-bot-think-time", "soon"},
+		"unknown flag":      {"-colour", "red"},
+	} {
+		if _, err := Load(args); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+
+	t.Setenv("ANALYTICS_BUFFER", "lots")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "ANALYTICS_BUFFER") {
+		t.Errorf("bad env value: err = %v, want it to name ANALYTICS_BUFFER", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults don't validate: %v", err)
+	}
+
+	cfg := Default()
+	cfg.Port = "99999"
+	cfg.KafkaBrokers = nil
+	cfg.ClusterNodeID = "node-a"
+	cfg.MatchmakingTimeout = Duration{0}
+	cfg.BotThinkTime = Duration{-time.Second}
+	cfg.AnalyticsBuffer = 0
+	cfg.AnalyticsOverflow = "shrug"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config validated")
+	}
+	// Every problem is reported, not just the first
+	for _, want := range []string{"port", "Kafka broker", "redis url", "matchmaking timeout", "bot think time", "analytics buffer", "analytics overflow"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+}
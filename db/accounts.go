@@ -0,0 +1,158 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrAccountExists = errors.New("account already exists")
+	ErrGuestNotFound = errors.New("guest not found")
+	// ErrGuestNameTaken means another guest already has the username
+	ErrGuestNameTaken = errors.New("guest username taken")
+)
+
+type Guest struct {
+	ID         string    `json:"guestId"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpgradedTo string    `json:"upgradedTo,omitempty"`
+}
+
+type Account struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+	// MergedGames is how many of a guest's games moved over to the account
+	MergedGames int `json:"mergedGames,omitempty"`
+}
+
+// SaveGuest records a guest identity, or bumps last_seen if we already know it.
+// It returns ErrGuestNameTaken if a different guest already has the username.
+func (r *SQLRepository) SaveGuest(id, username string) error {
+	now := time.Now()
+	res, err := r.exec(`UPDATE guests SET last_seen = $2 WHERE guest_id = $1`, id, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	res, err = r.exec(`
+	INSERT INTO guests (guest_id, username, created_at, last_seen)
+	VALUES ($1, $2, $3, $3)
+	ON CONFLICT DO NOTHING
+	`, id, username, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrGuestNameTaken
+	}
+	return nil
+}
+
+func (r *SQLRepository) GetGuest(id string) (*Guest, error) {
+	var g Guest
+	var upgraded sql.NullString
+	err := r.queryRow(`
+	SELECT guest_id, username, created_at, upgraded_to FROM guests WHERE guest_id = $1
+	`, id).Scan(&g.ID, &g.Username, &g.CreatedAt, &upgraded)
+	if err == sql.ErrNoRows {
+		return nil, ErrGuestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	g.UpgradedTo = upgraded.String
+	return &g, nil
+}
+
+// CreateAccount inserts a new account. If guestID is set, the guest's game
+// history and rating are moved over to the new username in the same transaction,
+// and the account's MergedGames says how many games moved.
+func (r *SQLRepository) CreateAccount(username, passwordHash, guestID string) (*Account, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.Exec(`
+	INSERT INTO accounts (username, password_hash, created_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (username) DO NOTHING
+	`, username, passwordHash, now)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrAccountExists
+	}
+
+	merged := 0
+	if guestID != "" {
+		if merged, err = mergeGuest(tx, guestID, username); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &Account{Username: username, CreatedAt: now, MergedGames: merged}, nil
+}
+
+// mergeGuest rewrites every game the guest played so it belongs to the account,
+// recounts both names' leaderboard stats, and carries their rating over unless
+// the account somehow already has one. It returns how many games moved.
+func mergeGuest(tx *dialectTx, guestID, username string) (int, error) {
+	var guestName string
+	var upgraded sql.NullString
+	err := tx.QueryRow(`
+	SELECT username, upgraded_to FROM guests WHERE guest_id = $1 FOR UPDATE
+	`, guestID).Scan(&guestName, &upgraded)
+	if err == sql.ErrNoRows {
+		return 0, ErrGuestNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Already merged into another account, nothing left to move
+	if upgraded.Valid {
+		return 0, nil
+	}
+
+	games := 0
+	for _, s := range []string{
+		`UPDATE games SET player1 = $2 WHERE player1 = $1`,
+		`UPDATE games SET player2 = $2 WHERE player2 = $1`,
+	} {
+		res, err := tx.Exec(s, guestName, username)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		games += int(n)
+	}
+
+	stmts := []string{
+		`UPDATE games SET winner = $2 WHERE winner = $1`,
+		`UPDATE moves SET player = $2 WHERE player = $1`,
+		`UPDATE player_ratings SET username = $2 WHERE username = $1
+		 AND NOT EXISTS (SELECT 1 FROM player_ratings WHERE username = $2)`,
+		`UPDATE guests SET upgraded_to = $2 WHERE username = $1`,
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s, guestName, username); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := rebuildStats(tx, guestName, username); err != nil {
+		return 0, err
+	}
+	return games, nil
+}
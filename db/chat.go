@@ -0,0 +1,47 @@
+package db
+
+import "fourinrow/game"
+
+const insertChat = `
+INSERT INTO chat_messages (game_id, username, kind, text, sent_at)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+func saveChat(tx *dialectTx, g *game.Game) error {
+	for _, m := range g.Chat {
+		if _, err := tx.Exec(insertChat, g.ID, m.From, m.Kind, m.Text, m.SentAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveChatMessage appends a message sent after the game was saved to its
+// chat log. It does nothing unless chat logs are on.
+func (r *SQLRepository) SaveChatMessage(gameID string, m game.ChatMessage) error {
+	if !r.persistChat {
+		return nil
+	}
+	_, err := r.exec(insertChat, gameID, m.From, m.Kind, m.Text, m.SentAt)
+	return err
+}
+
+// SetBlocked adds or removes target from username's block list
+func (r *SQLRepository) SetBlocked(username, target string, on bool) error {
+	var err error
+	if on {
+		_, err = r.exec(`
+		INSERT INTO blocks (username, blocked) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`, username, target)
+	} else {
+		_, err = r.exec(`DELETE FROM blocks WHERE username = $1 AND blocked = $2`, username, target)
+	}
+	return err
+}
+
+func (r *SQLRepository) IsBlocked(username, target string) (bool, error) {
+	var n int
+	err := r.queryRow(`SELECT COUNT(*) FROM blocks WHERE username = $1 AND blocked = $2`, username, target).Scan(&n)
+	return n > 0, err
+}
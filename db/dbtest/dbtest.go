@@ -0,0 +1,894 @@
+// Package dbtest is the conformance suite every db.Repository must pass.
+// Cases only look at rows they created themselves, under fresh usernames, so
+// the suite can run against a database that already holds data.
+//
+// db/repository_test.go runs it against every backend:
+//
+//	for _, c := range dbtest.Cases {
+//		t.Run(c.Name, func(t *testing.T) { c.Run(t, repo) })
+//	}
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/db"
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+// T is the part of *testing.T the cases use. Fatalf must stop the case.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+type Case struct {
+	Name string
+	Run  func(t T, repo db.Repository)
+}
+
+var Cases = []Case{
+	{"SaveAndGetGame", testSaveAndGetGame},
+	{"SaveGameTwice", testSaveGameTwice},
+	{"SaveGameWithoutWinner", testSaveGameWithoutWinner},
+	{"GetMissingGame", testGetMissingGame},
+	{"Moves", testMoves},
+	{"ListGamesForPlayer", testListGamesForPlayer},
+	{"PlayerStats", testPlayerStats},
+	{"HeadToHead", testHeadToHead},
+	{"Series", testSeries},
+	{"RatedGame", testRatedGame},
+	{"ResaveRatesOnce", testResaveRatesOnce},
+	{"BotGameUnrated", testBotGameUnrated},
+	{"Leaderboard", testLeaderboard},
+	{"RebuildPlayerStats", testRebuildPlayerStats},
+	{"Guests", testGuests},
+	{"CreateAccount", testCreateAccount},
+	{"CreateAccountMergesGuest", testCreateAccountMergesGuest},
+	{"Blocks", testBlocks},
+	{"Snapshots", testSnapshots},
+	{"Outbox", testOutbox},
+}
+
+// name returns a username no other run has used
+func name(prefix string) string {
+	return prefix + "-" + uuid.NewString()[:8]
+}
+
+type player struct {
+	name  string
+	isBot bool
+}
+
+// newGame builds a finished game between a (color 1) and b. winner is a
+// username, "draw", or "" for a game that hasn't ended.
+func newGame(a, b player, winner string) *game.Game {
+	g := &game.Game{ID: uuid.NewString(), Players: map[string]*game.Player{}, Status: "finished"}
+	for i, p := range []player{a, b} {
+		id := uuid.NewString()
+		g.Players[id] = &game.Player{ID: id, Username: p.name, Color: i + 1, IsBot: p.isBot}
+		if p.name == winner {
+			g.Winner = id
+		}
+	}
+	if winner == "draw" {
+		g.Winner = "draw"
+	}
+	return g
+}
+
+func save(t T, repo db.Repository, g *game.Game) map[string]game.RatingChange {
+	t.Helper()
+	changes, err := repo.SaveGame(g)
+	if err != nil {
+		t.Fatalf("SaveGame: %v", err)
+	}
+	return changes
+}
+
+func testSaveAndGetGame(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, a)
+	before := time.Now().Add(-time.Second)
+	save(t, repo, g)
+
+	sg, err := repo.GetGame(g.ID)
+	if err != nil {
+		t.Fatalf("GetGame: %v", err)
+	}
+	if sg.ID != g.ID || sg.Player1 != a || sg.Player2 != b || sg.Winner != a {
+		t.Errorf("GetGame = %+v, want %s: %s vs %s won by %s", sg, g.ID, a, b, a)
+	}
+	if sg.SeriesID != "" {
+		t.Errorf("SeriesID = %q, want none", sg.SeriesID)
+	}
+	if sg.FinishedAt.Before(before) || sg.CreatedAt.After(sg.FinishedAt) {
+		t.Errorf("times created=%v finished=%v, want around %v", sg.CreatedAt, sg.FinishedAt, before)
+	}
+}
+
+func testSaveGameTwice(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, "draw")
+	save(t, repo, g)
+	for id, p := range g.Players {
+		if p.Username == b {
+			g.Winner = id
+		}
+	}
+	save(t, repo, g)
+
+	sg, err := repo.GetGame(g.ID)
+	if err != nil {
+		t.Fatalf("GetGame: %v", err)
+	}
+	if sg.Winner != b {
+		t.Errorf("Winner after resave = %q, want %q", sg.Winner, b)
+	}
+	games, err := repo.ListGamesForPlayer(a, 10, 0)
+	if err != nil {
+		t.Fatalf("ListGamesForPlayer: %v", err)
+	}
+	if len(games) != 1 {
+		t.Errorf("saving twice stored %d games, want 1", len(games))
+	}
+}
+
+func testSaveGameWithoutWinner(t T, repo db.Repository) {
+	g := newGame(player{name: name("alice")}, player{name: name("bob")}, "")
+	if changes := save(t, repo, g); changes != nil {
+		t.Errorf("unfinished game changed ratings: %v", changes)
+	}
+	if _, err := repo.GetGame(g.ID); !errors.Is(err, db.ErrGameNotFound) {
+		t.Errorf("unfinished game was stored (GetGame err = %v)", err)
+	}
+}
+
+func testGetMissingGame(t T, repo db.Repository) {
+	if _, err := repo.GetGame(uuid.NewString()); !errors.Is(err, db.ErrGameNotFound) {
+		t.Errorf("GetGame(missing) err = %v, want ErrGameNotFound", err)
+	}
+}
+
+func testMoves(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, a)
+	g.Variant, g.EndReason = "classic", game.EndConnectFour
+	g.CreatedAt = time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	ids := map[string]string{}
+	for id, p := range g.Players {
+		ids[p.Username] = id
+	}
+	for i, at := range []time.Duration{10 * time.Second, 25 * time.Second, 40 * time.Second} {
+		by := ids[a]
+		if i%2 == 1 {
+			by = ids[b]
+		}
+		g.Moves = append(g.Moves, game.Move{Ply: i + 1, PlayerID: by, Column: 3, Row: 5 - i, At: g.CreatedAt.Add(at)})
+	}
+	g.FinishedAt = g.CreatedAt.Add(45 * time.Second)
+	save(t, repo, g)
+	save(t, repo, g) // saving again must not duplicate moves
+
+	sg, err := repo.GetGame(g.ID)
+	if err != nil {
+		t.Fatalf("GetGame: %v", err)
+	}
+	if sg.Variant != "classic" || sg.EndReason != game.EndConnectFour || sg.MoveCount != 3 || sg.DurationMs != 45000 {
+		t.Errorf("GetGame = %+v, want classic, connect_four, 3 moves, 45000ms", sg)
+	}
+	if got := sg.FinishedAt.Sub(sg.CreatedAt); got != 45*time.Second {
+		t.Errorf("finished - created = %v, want the game's real 45s", got)
+	}
+
+	moves, err := repo.GetMoves(g.ID)
+	if err != nil {
+		t.Fatalf("GetMoves: %v", err)
+	}
+	want := []db.StoredMove{
+		{Ply: 1, Player: a, Column: 3, Row: 5, ThinkMs: 10000},
+		{Ply: 2, Player: b, Column: 3, Row: 4, ThinkMs: 15000},
+		{Ply: 3, Player: a, Column: 3, Row: 3, ThinkMs: 15000},
+	}
+	if len(moves) != len(want) {
+		t.Fatalf("GetMoves = %+v, want %d moves", moves, len(want))
+	}
+	for i, m := range moves {
+		played := m.PlayedAt
+		m.PlayedAt = time.Time{}
+		if m != want[i] {
+			t.Errorf("move %d = %+v, want %+v", i+1, m, want[i])
+		}
+		if i > 0 && !played.After(moves[i-1].PlayedAt) {
+			t.Errorf("move %d played at %v, not after the previous move", i+1, played)
+		}
+	}
+
+	if moves, err := repo.GetMoves(uuid.NewString()); err != nil || len(moves) != 0 {
+		t.Errorf("GetMoves(missing) = %v, %v, want none", moves, err)
+	}
+}
+
+func testListGamesForPlayer(t T, repo db.Repository) {
+	a := name("alice")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		// As either color, so both player columns are searched
+		g := newGame(player{name: a}, player{name: name("opp")}, a)
+		if i == 1 {
+			g = newGame(player{name: name("opp")}, player{name: a}, "draw")
+		}
+		save(t, repo, g)
+		ids = append(ids, g.ID)
+		time.Sleep(10 * time.Millisecond) // distinct finish times
+	}
+	save(t, repo, newGame(player{name: name("carol")}, player{name: name("dave")}, "draw"))
+
+	games, err := repo.ListGamesForPlayer(a, 10, 0)
+	if err != nil {
+		t.Fatalf("ListGamesForPlayer: %v", err)
+	}
+	if got := gameIDs(games); fmt.Sprint(got) != fmt.Sprint([]string{ids[2], ids[1], ids[0]}) {
+		t.Errorf("games = %v, want newest first %v", got, []string{ids[2], ids[1], ids[0]})
+	}
+
+	games, err = repo.ListGamesForPlayer(a, 2, 1)
+	if err != nil {
+		t.Fatalf("ListGamesForPlayer page: %v", err)
+	}
+	if got := gameIDs(games); fmt.Sprint(got) != fmt.Sprint([]string{ids[1], ids[0]}) {
+		t.Errorf("limit 2 offset 1 = %v, want %v", got, []string{ids[1], ids[0]})
+	}
+
+	games, err = repo.ListGamesForPlayer(name("nobody"), 10, 0)
+	if err != nil || len(games) != 0 {
+		t.Errorf("unknown player: %v, %v, want no games", games, err)
+	}
+}
+
+func testPlayerStats(t T, repo db.Repository) {
+	a := name("alice")
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	// Oldest first: W W W L D W, opening 3, 3, -, 0, -, 6
+	games := []struct {
+		first, second player
+		winner        string
+		opening       int // column a opens with, -1 if the opponent moves first
+		moves         int
+	}{
+		{player{name: a}, player{name: name("opp")}, a, 3, 3},
+		{player{name: a}, player{name: name("opp")}, a, 3, 1},
+		{player{name: name("opp")}, player{name: a}, a, -1, 1},
+		{player{name: a}, player{name: game.BotUsername, isBot: true}, game.BotUsername, 0, 1},
+		{player{name: name("opp")}, player{name: a}, "draw", -1, 1},
+		{player{name: a}, player{name: name("opp")}, a, 6, 1},
+	}
+	for i, spec := range games {
+		g := newGame(spec.first, spec.second, spec.winner)
+		g.FinishedAt = start.Add(time.Duration(i) * time.Minute)
+		g.CreatedAt = g.FinishedAt.Add(-time.Minute)
+		ids := map[int]string{}
+		for id, p := range g.Players {
+			ids[p.Color] = id
+		}
+		for ply := 1; ply <= spec.moves; ply++ {
+			column := 1
+			if ply == 1 && spec.opening >= 0 {
+				column = spec.opening
+			}
+			g.Moves = append(g.Moves, game.Move{Ply: ply, PlayerID: ids[2-ply%2], Column: column, Row: 5, At: g.CreatedAt.Add(time.Duration(ply) * time.Second)})
+		}
+		save(t, repo, g)
+	}
+
+	s, err := repo.GetPlayerStats(a)
+	if err != nil {
+		t.Fatalf("GetPlayerStats: %v", err)
+	}
+	check := func(what string, got, want db.Results) {
+		t.Helper()
+		if want.Games > 0 {
+			want.WinRate = float64(want.Wins) / float64(want.Games)
+		}
+		if got != want {
+			t.Errorf("%s = %+v, want %+v", what, got, want)
+		}
+	}
+	check("totals", s.Results, db.Results{Games: 6, Wins: 4, Losses: 1, Draws: 1})
+	check("as player 1", s.AsPlayer1, db.Results{Games: 4, Wins: 3, Losses: 1})
+	check("as player 2", s.AsPlayer2, db.Results{Games: 2, Wins: 1, Draws: 1})
+	check("pvp", s.PvP, db.Results{Games: 5, Wins: 4, Draws: 1})
+	check("vs bot", s.VsBot, db.Results{Games: 1, Losses: 1})
+	if s.LongestWinStreak != 3 {
+		t.Errorf("LongestWinStreak = %d, want 3", s.LongestWinStreak)
+	}
+	if want := 8.0 / 6; s.AvgMoves < want-1e-9 || s.AvgMoves > want+1e-9 {
+		t.Errorf("AvgMoves = %v, want %v", s.AvgMoves, want)
+	}
+	if s.AvgDurationMs != 60000 {
+		t.Errorf("AvgDurationMs = %d, want 60000", s.AvgDurationMs)
+	}
+	if s.FavoriteOpening == nil || *s.FavoriteOpening != 3 {
+		t.Errorf("FavoriteOpening = %v, want column 3", s.FavoriteOpening)
+	}
+
+	s, err = repo.GetPlayerStats(name("nobody"))
+	if err != nil {
+		t.Fatalf("GetPlayerStats(unknown): %v", err)
+	}
+	if s.Games != 0 || s.LongestWinStreak != 0 || s.FavoriteOpening != nil {
+		t.Errorf("unknown player stats = %+v, want empty", s)
+	}
+}
+
+func gameIDs(games []db.StoredGame) []string {
+	ids := make([]string, len(games))
+	for i, g := range games {
+		ids[i] = g.ID
+	}
+	return ids
+}
+
+func testHeadToHead(t T, repo db.Repository) {
+	a, b, c := name("alice"), name("bob"), name("carol")
+	start := time.Now().Add(-time.Hour)
+	var ids []string
+	for i, m := range []struct{ p1, p2, winner string }{{a, b, a}, {b, a, b}, {a, b, "draw"}, {a, c, a}} {
+		g := newGame(player{name: m.p1}, player{name: m.p2}, m.winner)
+		g.FinishedAt = start.Add(time.Duration(i) * time.Minute)
+		save(t, repo, g)
+		ids = append(ids, g.ID)
+	}
+	save(t, repo, newGame(player{name: a}, player{name: game.BotUsername, isBot: true}, a))
+
+	h, err := repo.GetHeadToHead(a, b, 10, 0)
+	if err != nil {
+		t.Fatalf("GetHeadToHead: %v", err)
+	}
+	if h.Games != 3 || h.Wins != 1 || h.Losses != 1 || h.Draws != 1 {
+		t.Errorf("record = %+v, want 1-1-1", h.Results)
+	}
+	if len(h.Recent) != 3 || h.Recent[0].ID != ids[2] || h.Recent[2].ID != ids[0] {
+		t.Fatalf("recent games = %+v, want %v newest first", h.Recent, ids[:3])
+	}
+	var sum, oppSum float64
+	for _, g := range h.Recent {
+		if g.PlayerRating == nil || g.OpponentRating == nil {
+			t.Fatalf("game %s has no rating changes", g.ID)
+		}
+		sum += g.PlayerRating.Delta
+		oppSum += g.OpponentRating.Delta
+	}
+	if math.Abs(h.RatingChange-sum) > 1e-6 || math.Abs(h.OpponentRatingChange-oppSum) > 1e-6 {
+		t.Errorf("rating change = %v/%v, want the per-game deltas' sums %v/%v", h.RatingChange, h.OpponentRatingChange, sum, oppSum)
+	}
+
+	// The other side sees the mirror image
+	h, err = repo.GetHeadToHead(b, a, 1, 1)
+	if err != nil {
+		t.Fatalf("GetHeadToHead: %v", err)
+	}
+	if h.Games != 3 || h.Wins != 1 || h.Losses != 1 {
+		t.Errorf("record from %s = %+v, want 1-1-1", b, h.Results)
+	}
+	if len(h.Recent) != 1 || h.Recent[0].ID != ids[1] {
+		t.Errorf("second page = %+v, want only %s", h.Recent, ids[1])
+	}
+	if math.Abs(h.RatingChange-oppSum) > 1e-6 {
+		t.Errorf("rating change from %s = %v, want %v", b, h.RatingChange, oppSum)
+	}
+
+	h, err = repo.GetHeadToHead(b, c, 10, 0)
+	if err != nil || h.Games != 0 || len(h.Recent) != 0 {
+		t.Errorf("strangers = %+v, %v, want no games", h, err)
+	}
+}
+
+func testSeries(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	first := newGame(player{name: a}, player{name: b}, a)
+	s := game.NewSeries(uuid.NewString(), first)
+	s.GameIDs = append(s.GameIDs, first.ID)
+	s.Record(first)
+	first.Series = s
+	save(t, repo, first)
+
+	second := newGame(player{name: b}, player{name: a}, a)
+	s.GameIDs = append(s.GameIDs, second.ID)
+	s.Record(second)
+	second.Series = s
+	save(t, repo, second)
+
+	for _, id := range []string{first.ID, second.ID} {
+		sg, err := repo.GetGame(id)
+		if err != nil {
+			t.Fatalf("GetGame: %v", err)
+		}
+		if sg.SeriesID != s.ID {
+			t.Errorf("game %s SeriesID = %q, want %q", id, sg.SeriesID, s.ID)
+		}
+	}
+}
+
+func testRatedGame(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, a)
+	changes := save(t, repo, g)
+	if len(changes) != 2 {
+		t.Fatalf("changes = %v, want one per player", changes)
+	}
+	if c := changes[a]; c.Before != db.DefaultRating || c.Delta <= 0 || c.After != c.Before+c.Delta {
+		t.Errorf("winner change = %+v", c)
+	}
+	if c := changes[b]; c.Before != db.DefaultRating || c.Delta >= 0 {
+		t.Errorf("loser change = %+v", c)
+	}
+
+	for _, u := range []string{a, b} {
+		got, err := repo.GetRating(u)
+		if err != nil {
+			t.Fatalf("GetRating: %v", err)
+		}
+		if got != changes[u].After {
+			t.Errorf("GetRating(%s) = %v, want %v", u, got, changes[u].After)
+		}
+		history, err := repo.GetRatingHistory(u, 10)
+		if err != nil {
+			t.Fatalf("GetRatingHistory: %v", err)
+		}
+		if len(history) != 1 || history[0].GameID != g.ID || history[0].RatingAfter != changes[u].After {
+			t.Errorf("GetRatingHistory(%s) = %+v", u, history)
+		}
+	}
+
+	// A second game starts from the updated ratings, and history is newest first
+	g2 := newGame(player{name: b}, player{name: a}, "draw")
+	changes2 := save(t, repo, g2)
+	if changes2[a].Before != changes[a].After {
+		t.Errorf("second game started %s at %v, want %v", a, changes2[a].Before, changes[a].After)
+	}
+	history, err := repo.GetRatingHistory(a, 1)
+	if err != nil {
+		t.Fatalf("GetRatingHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].GameID != g2.ID {
+		t.Errorf("GetRatingHistory limit 1 = %+v, want only %s", history, g2.ID)
+	}
+}
+
+func testResaveRatesOnce(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, a)
+	changes := save(t, repo, g)
+	if again := save(t, repo, g); again[a] != changes[a] || again[b] != changes[b] {
+		t.Errorf("resave reported %v, want the first save's %v", again, changes)
+	}
+
+	if got, _ := repo.GetRating(a); got != changes[a].After {
+		t.Errorf("rating after resave = %v, want %v", got, changes[a].After)
+	}
+	if history, _ := repo.GetRatingHistory(a, 10); len(history) != 1 {
+		t.Errorf("history after resave = %+v, want one entry", history)
+	}
+	h, err := repo.GetHeadToHead(a, b, 10, 0)
+	if err != nil {
+		t.Fatalf("GetHeadToHead: %v", err)
+	}
+	if math.Abs(h.RatingChange-changes[a].Delta) > 1e-6 {
+		t.Errorf("head-to-head rating change = %v, want %v", h.RatingChange, changes[a].Delta)
+	}
+
+	// Only the first save rates, even if a resave carries a different result
+	g.Winner = "draw"
+	if again := save(t, repo, g); again[a] != changes[a] {
+		t.Errorf("resave with a new result reported %v, want the first save's %v", again, changes)
+	}
+	if got, _ := repo.GetRating(b); got != changes[b].After {
+		t.Errorf("rating after a resave with a new result = %v, want %v", got, changes[b].After)
+	}
+}
+
+func testBotGameUnrated(t T, repo db.Repository) {
+	a := name("alice")
+	g := newGame(player{name: a}, player{name: game.BotUsername, isBot: true}, a)
+	if changes := save(t, repo, g); changes != nil {
+		t.Errorf("bot game changed ratings: %v", changes)
+	}
+	if got, err := repo.GetRating(a); err != nil || got != db.DefaultRating {
+		t.Errorf("GetRating after bot game = %v, %v, want %v", got, err, db.DefaultRating)
+	}
+	if _, err := repo.GetGame(g.ID); err != nil {
+		t.Errorf("bot game not stored: %v", err)
+	}
+}
+
+func testLeaderboard(t T, repo db.Repository) {
+	// A variant of its own keeps other data off this leaderboard
+	variant := name("conform")
+	a, b, c, d, e := name("a"), name("b"), name("c"), name("d"), name("e")
+	bot := player{name: game.BotUsername, isBot: true}
+	play := func(p1, p2 player, winner string, finished time.Time) {
+		t.Helper()
+		g := newGame(p1, p2, winner)
+		g.Variant, g.FinishedAt = variant, finished
+		save(t, repo, g)
+	}
+	now := time.Now()
+	play(player{name: b}, player{name: a}, b, now.AddDate(0, 0, -40))
+	play(player{name: a}, player{name: b}, a, now)
+	play(player{name: b}, player{name: a}, a, now)
+	play(player{name: a}, player{name: c}, a, now)
+	play(player{name: b}, player{name: c}, b, now)
+	play(player{name: c}, player{name: d}, "draw", now)
+	play(player{name: a}, bot, a, now)
+	play(player{name: e}, bot, e, now)
+	play(bot, player{name: e}, e, now) // older games may seat the bot first
+
+	q := func(mode, metric string) db.LeaderboardQuery {
+		return db.LeaderboardQuery{Mode: mode, Variant: variant, Metric: metric, MinGames: 1, Limit: 10}
+	}
+	check := func(what string, q db.LeaderboardQuery, want ...string) []db.LeaderboardEntry {
+		t.Helper()
+		board, err := repo.GetLeaderboard(q)
+		if err != nil {
+			t.Fatalf("GetLeaderboard(%s): %v", what, err)
+		}
+		var got []string
+		for i, entry := range board {
+			got = append(got, entry.Username)
+			if entry.Rank != q.Offset+i+1 {
+				t.Errorf("%s: %s has rank %d, want %d", what, entry.Username, entry.Rank, q.Offset+i+1)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s = %v, want %v", what, got, want)
+		}
+		return board
+	}
+
+	board := check("pvp wins", q(db.ModePvP, db.MetricWins), a, b, d, c)
+	if board[0].TotalWins != 3 || board[0].Games != 4 || board[0].WinRate != 0.75 {
+		t.Errorf("pvp wins: %s = %+v, want 3 wins in 4 games", a, board[0])
+	}
+
+	recent := q(db.ModePvP, db.MetricWins)
+	recent.Since = now.AddDate(0, 0, -1)
+	board = check("recent pvp wins", recent, a, b, d, c)
+	if board[1].TotalWins != 1 || board[1].Games != 3 {
+		t.Errorf("recent pvp wins: %s = %+v, want the old game left out", b, board[1])
+	}
+
+	check("bot wins", q(db.ModeBot, db.MetricWins), e, a)
+	check("all wins", q(db.ModeAll, db.MetricWins), a, e, b, d, c)
+
+	rate := q(db.ModePvP, db.MetricWinRate)
+	rate.MinGames = 2
+	check("pvp win rate", rate, a, b, c)
+
+	board, err := repo.GetLeaderboard(q(db.ModePvP, db.MetricRating))
+	if err != nil {
+		t.Fatalf("GetLeaderboard(rating): %v", err)
+	}
+	if len(board) != 4 || board[0].Username != a {
+		t.Errorf("pvp rating = %+v, want 4 players led by %s", board, a)
+	}
+	for i := 1; i < len(board); i++ {
+		if board[i].Rating > board[i-1].Rating {
+			t.Errorf("pvp rating not sorted: %+v", board)
+		}
+	}
+
+	paged := q(db.ModePvP, db.MetricWins)
+	paged.Limit, paged.Offset = 2, 2
+	check("pvp wins page 2", paged, d, c)
+
+	pos, err := repo.GetLeaderboardPosition(paged, c)
+	if err != nil {
+		t.Fatalf("GetLeaderboardPosition: %v", err)
+	}
+	if pos == nil || pos.Rank != 4 || pos.Username != c || pos.Games != 3 {
+		t.Errorf("position of %s = %+v, want rank 4 with 3 games", c, pos)
+	}
+	if pos, err := repo.GetLeaderboardPosition(paged, e); err != nil || pos != nil {
+		t.Errorf("position of bot-only %s on pvp = %+v, %v, want none", e, pos, err)
+	}
+	if pos, err := repo.GetLeaderboardPosition(q(db.ModeAll, db.MetricWins), game.BotUsername); err != nil || pos != nil {
+		t.Errorf("the bot is on the leaderboard: %+v, %v", pos, err)
+	}
+}
+
+func testRebuildPlayerStats(t T, repo db.Repository) {
+	variant := name("conform")
+	a, b := name("a"), name("b")
+	q := db.LeaderboardQuery{Mode: db.ModeAll, Variant: variant, Metric: db.MetricWins, MinGames: 1, Limit: 10}
+	board := func(what string) string {
+		t.Helper()
+		entries, err := repo.GetLeaderboard(q)
+		if err != nil {
+			t.Fatalf("GetLeaderboard(%s): %v", what, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, fmt.Sprintf("%s:%d/%d", e.Username, e.TotalWins, e.Games))
+		}
+		return fmt.Sprint(got)
+	}
+
+	g := newGame(player{name: a}, player{name: b}, "draw")
+	g.Variant = variant
+	save(t, repo, g)
+	// A resave with a different result replaces the first one
+	for id, p := range g.Players {
+		if p.Username == b {
+			g.Winner = id
+		}
+	}
+	save(t, repo, g)
+	g2 := newGame(player{name: a}, player{name: game.BotUsername, isBot: true}, a)
+	g2.Variant = variant
+	save(t, repo, g2)
+
+	want := fmt.Sprint([]string{b + ":1/1", a + ":1/2"})
+	if got := board("incremental"); got != want {
+		t.Errorf("leaderboard after saves = %s, want %s", got, want)
+	}
+	n, err := repo.RebuildPlayerStats()
+	if err != nil {
+		t.Fatalf("RebuildPlayerStats: %v", err)
+	}
+	if n < 2 {
+		t.Errorf("RebuildPlayerStats counted %d games, want at least 2", n)
+	}
+	if got := board("rebuilt"); got != want {
+		t.Errorf("leaderboard after rebuild = %s, want %s", got, want)
+	}
+}
+
+func testGuests(t T, repo db.Repository) {
+	id, u := uuid.NewString(), name("guest")
+	if err := repo.SaveGuest(id, u); err != nil {
+		t.Fatalf("SaveGuest: %v", err)
+	}
+	if err := repo.SaveGuest(id, u); err != nil {
+		t.Fatalf("SaveGuest again: %v", err)
+	}
+	g, err := repo.GetGuest(id)
+	if err != nil {
+		t.Fatalf("GetGuest: %v", err)
+	}
+	if g.ID != id || g.Username != u || g.UpgradedTo != "" {
+		t.Errorf("GetGuest = %+v", g)
+	}
+	if _, err := repo.GetGuest(uuid.NewString()); !errors.Is(err, db.ErrGuestNotFound) {
+		t.Errorf("GetGuest(missing) err = %v, want ErrGuestNotFound", err)
+	}
+
+	// A second guest can't take the same name
+	other := uuid.NewString()
+	if err := repo.SaveGuest(other, u); !errors.Is(err, db.ErrGuestNameTaken) {
+		t.Errorf("SaveGuest with a taken name err = %v, want ErrGuestNameTaken", err)
+	}
+	if _, err := repo.GetGuest(other); !errors.Is(err, db.ErrGuestNotFound) {
+		t.Errorf("GetGuest after a name clash err = %v, want ErrGuestNotFound", err)
+	}
+}
+
+func testCreateAccount(t T, repo db.Repository) {
+	u := name("user")
+	acc, err := repo.CreateAccount(u, "hash", "")
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	if acc.Username != u {
+		t.Errorf("account = %+v", acc)
+	}
+	if _, err := repo.CreateAccount(u, "hash", ""); !errors.Is(err, db.ErrAccountExists) {
+		t.Errorf("duplicate CreateAccount err = %v, want ErrAccountExists", err)
+	}
+
+	// A bad guest ID fails the whole thing, leaving the name free
+	u2 := name("user")
+	if _, err := repo.CreateAccount(u2, "hash", uuid.NewString()); !errors.Is(err, db.ErrGuestNotFound) {
+		t.Errorf("CreateAccount with missing guest err = %v, want ErrGuestNotFound", err)
+	}
+	if _, err := repo.CreateAccount(u2, "hash", ""); err != nil {
+		t.Errorf("CreateAccount after failed merge: %v", err)
+	}
+}
+
+func testCreateAccountMergesGuest(t T, repo db.Repository) {
+	guestID, guestName, opp := uuid.NewString(), name("guest"), name("opp")
+	if err := repo.SaveGuest(guestID, guestName); err != nil {
+		t.Fatalf("SaveGuest: %v", err)
+	}
+	g := newGame(player{name: guestName}, player{name: opp}, guestName)
+	for id, p := range g.Players {
+		if p.Username == guestName {
+			g.Moves = []game.Move{{Ply: 1, PlayerID: id, Column: 0, Row: 5, At: time.Now()}}
+		}
+	}
+	changes := save(t, repo, g)
+
+	u := name("user")
+	acc, err := repo.CreateAccount(u, "hash", guestID)
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	if acc.MergedGames != 1 {
+		t.Errorf("MergedGames = %d, want 1", acc.MergedGames)
+	}
+
+	sg, err := repo.GetGame(g.ID)
+	if err != nil {
+		t.Fatalf("GetGame: %v", err)
+	}
+	if sg.Player1 != u || sg.Winner != u {
+		t.Errorf("merged game = %+v, want it to belong to %s", sg, u)
+	}
+	if moves, err := repo.GetMoves(g.ID); err != nil || len(moves) != 1 || moves[0].Player != u {
+		t.Errorf("merged moves = %+v, %v, want them played by %s", moves, err, u)
+	}
+	if got, _ := repo.GetRating(u); got != changes[guestName].After {
+		t.Errorf("merged rating = %v, want %v", got, changes[guestName].After)
+	}
+	guest, err := repo.GetGuest(guestID)
+	if err != nil {
+		t.Fatalf("GetGuest: %v", err)
+	}
+	if guest.UpgradedTo != u {
+		t.Errorf("guest UpgradedTo = %q, want %q", guest.UpgradedTo, u)
+	}
+
+	// A guest without games, or one already upgraded, moves nothing
+	idle := uuid.NewString()
+	if err := repo.SaveGuest(idle, name("guest")); err != nil {
+		t.Fatalf("SaveGuest: %v", err)
+	}
+	for _, id := range []string{idle, guestID} {
+		acc, err := repo.CreateAccount(name("user"), "hash", id)
+		if err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+		if acc.MergedGames != 0 {
+			t.Errorf("MergedGames for guest %s = %d, want 0", id, acc.MergedGames)
+		}
+	}
+}
+
+func testBlocks(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	check := func(want bool) {
+		t.Helper()
+		got, err := repo.IsBlocked(a, b)
+		if err != nil {
+			t.Fatalf("IsBlocked: %v", err)
+		}
+		if got != want {
+			t.Errorf("IsBlocked = %v, want %v", got, want)
+		}
+	}
+	check(false)
+	if err := repo.SetBlocked(a, b, true); err != nil {
+		t.Fatalf("SetBlocked: %v", err)
+	}
+	if err := repo.SetBlocked(a, b, true); err != nil {
+		t.Fatalf("SetBlocked twice: %v", err)
+	}
+	check(true)
+	if blocked, _ := repo.IsBlocked(b, a); blocked {
+		t.Errorf("blocking is one-way, but %s blocks %s", b, a)
+	}
+	if err := repo.SetBlocked(a, b, false); err != nil {
+		t.Fatalf("SetBlocked off: %v", err)
+	}
+	check(false)
+}
+
+func testSnapshots(t T, repo db.Repository) {
+	g := newGame(player{name: name("alice")}, player{name: name("bob")}, "")
+	g.Status = "playing"
+	g.Board[5][3] = 1
+	if err := repo.SaveSnapshot(g); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	g.Board[4][3] = 2
+	if err := repo.SaveSnapshot(g); err != nil {
+		t.Fatalf("SaveSnapshot again: %v", err)
+	}
+
+	find := func() *game.Game {
+		t.Helper()
+		games, err := repo.LoadSnapshots()
+		if err != nil {
+			t.Fatalf("LoadSnapshots: %v", err)
+		}
+		for _, s := range games {
+			if s.ID == g.ID {
+				return s
+			}
+		}
+		return nil
+	}
+	s := find()
+	if s == nil {
+		t.Fatalf("snapshot %s not loaded", g.ID)
+	}
+	if s.Board != g.Board || len(s.Players) != 2 {
+		t.Errorf("snapshot = %+v, want the latest save", s)
+	}
+
+	if err := repo.DeleteSnapshot(g.ID); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if find() != nil {
+		t.Errorf("snapshot %s still loaded after delete", g.ID)
+	}
+}
+
+func testOutbox(t T, repo db.Repository) {
+	a, b := name("alice"), name("bob")
+	g := newGame(player{name: a}, player{name: b}, a)
+	save(t, repo, g)
+	// A resave replaces the waiting event rather than queueing another
+	for id, p := range g.Players {
+		if p.Username == b {
+			g.Winner = id
+		}
+	}
+	save(t, repo, g)
+
+	id := "game_finished:" + g.ID
+	claim := func() []analytics.GameEvent {
+		t.Helper()
+		// Other cases' events are pending too; look far enough to find ours
+		events, err := repo.ClaimEvents(100000, time.Minute)
+		if err != nil {
+			t.Fatalf("ClaimEvents: %v", err)
+		}
+		var mine []analytics.GameEvent
+		for _, e := range events {
+			if e.EventID == id {
+				mine = append(mine, e)
+			}
+		}
+		return mine
+	}
+
+	events := claim()
+	if len(events) != 1 {
+		t.Fatalf("claimed %s = %d events, want 1", id, len(events))
+	}
+	if e := events[0]; e.Type != "game_finished" || e.GameID != g.ID || e.PlayerID != g.Winner {
+		t.Errorf("event = %+v, want game_finished for %s won by %s", e, g.ID, g.Winner)
+	}
+	if len(claim()) != 0 {
+		t.Errorf("claimed event was handed out twice")
+	}
+
+	if err := repo.MarkEventsFailed([]string{id}, "broker down"); err != nil {
+		t.Fatalf("MarkEventsFailed: %v", err)
+	}
+	if len(claim()) != 1 {
+		t.Errorf("failed event was not released")
+	}
+	if err := repo.MarkEventsSent([]string{id}); err != nil {
+		t.Fatalf("MarkEventsSent: %v", err)
+	}
+	if err := repo.MarkEventsFailed([]string{id}, "late failure"); err != nil {
+		t.Fatalf("MarkEventsFailed: %v", err)
+	}
+	if len(claim()) != 0 {
+		t.Errorf("sent event is still pending")
+	}
+	save(t, repo, g)
+	if len(claim()) != 0 {
+		t.Errorf("resaving requeued a sent event")
+	}
+}
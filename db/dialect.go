@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect is what differs between the SQL backends. Queries are written for
+// Postgres and rewritten on the way out for the others.
+type Dialect struct {
+	Name   string
+	driver string
+	// Embedded directory holding this dialect's migrations
+	migrations string
+	// Statements run around a migration session to keep other processes out
+	lock, unlock string
+	// Whether SELECT ... FOR UPDATE [SKIP LOCKED] is supported. SQLite
+	// serializes writers on its own, so it just drops the clause.
+	rowLocks bool
+	// Whether placeholders are $1-style (Postgres) or ?1-style (SQLite)
+	dollarParams bool
+}
+
+var (
+	Postgres = &Dialect{
+		Name: "postgres", driver: "postgres", migrations: "migrations/postgres",
+		lock:     `SELECT pg_advisory_lock(1718186496)`, // "fir\x00"
+		unlock:   `SELECT pg_advisory_unlock(1718186496)`,
+		rowLocks: true, dollarParams: true,
+	}
+	SQLite = &Dialect{Name: "sqlite", driver: "sqlite3", migrations: "migrations/sqlite"}
+)
+
+var dollarParam = regexp.MustCompile(`\$(\d+)`)
+
+// rebind rewrites a Postgres-style query for d
+func (d *Dialect) rebind(query string) string {
+	if !d.rowLocks {
+		query = strings.ReplaceAll(query, " FOR UPDATE SKIP LOCKED", "")
+		query = strings.ReplaceAll(query, " FOR UPDATE", "")
+	}
+	if !d.dollarParams {
+		// ?NNN keeps the numbering, so "$2 ... $1" still binds correctly
+		query = dollarParam.ReplaceAllString(query, "?$1")
+	}
+	return query
+}
+
+// ParseURL picks the dialect for a DATABASE_URL and returns the driver's
+// data source name. postgres:// and postgresql:// URLs go to Postgres;
+// sqlite:PATH (or sqlite://PATH) opens a SQLite file.
+func ParseURL(url string) (*Dialect, string, error) {
+	switch {
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		return Postgres, url, nil
+	case strings.HasPrefix(url, "sqlite:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(url, "sqlite:"), "//")
+		if path == "" {
+			return nil, "", fmt.Errorf("sqlite url %q has no file path", url)
+		}
+		// One writer at a time, waiting rather than failing when busy
+		return SQLite, "file:" + path + "?_busy_timeout=5000&_txlock=immediate&_foreign_keys=on", nil
+	}
+	return nil, "", fmt.Errorf("unsupported database url %q (want postgres://, sqlite: or memory:)", url)
+}
+
+// Connect opens a pool for url and checks the server is reachable
+func Connect(url string) (*sql.DB, *Dialect, error) {
+	dialect, dsn, err := ParseURL(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dialect == SQLite {
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, dialect, nil
+}
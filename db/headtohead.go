@@ -0,0 +1,122 @@
+package db
+
+import "fourinrow/game"
+
+// HeadToHead is a rivalry seen from Player's side: Wins are Player's wins and
+// Losses are Opponent's
+type HeadToHead struct {
+	Player   string `json:"player"`
+	Opponent string `json:"opponent"`
+	Results
+	// Net rating each gained across their rated games together
+	RatingChange         float64 `json:"ratingChange"`
+	OpponentRatingChange float64 `json:"opponentRatingChange"`
+	// A page of their games, newest first
+	Recent []HeadToHeadGame `json:"recentGames"`
+}
+
+// HeadToHeadGame is a game between the two with what it did to their ratings;
+// the changes are nil for unrated games
+type HeadToHeadGame struct {
+	StoredGame
+	PlayerRating   *game.RatingChange `json:"playerRating,omitempty"`
+	OpponentRating *game.RatingChange `json:"opponentRating,omitempty"`
+}
+
+// h2hRating is one rating_history row from a game between the two
+type h2hRating struct {
+	gameID, username string
+	before, after    float64
+}
+
+// addRatings totals the rating movement and attaches each change to its game
+func (h *HeadToHead) addRatings(ratings []h2hRating) {
+	byGame := make(map[string]int)
+	for i := range h.Recent {
+		byGame[h.Recent[i].ID] = i
+	}
+	for _, rh := range ratings {
+		change := &game.RatingChange{Before: rh.before, After: rh.after, Delta: rh.after - rh.before}
+		i, onPage := byGame[rh.gameID]
+		switch rh.username {
+		case h.Player:
+			h.RatingChange += change.Delta
+			if onPage {
+				h.Recent[i].PlayerRating = change
+			}
+		case h.Opponent:
+			h.OpponentRatingChange += change.Delta
+			if onPage {
+				h.Recent[i].OpponentRating = change
+			}
+		}
+	}
+}
+
+// Games between $1 and $2 in either seat, using games_pair_idx
+const pairFilter = `((player1 = $1 AND player2 = $2) OR (player1 = $2 AND player2 = $1))`
+
+func (r *SQLRepository) GetHeadToHead(player, opponent string, limit, offset int) (*HeadToHead, error) {
+	h := &HeadToHead{Player: player, Opponent: opponent, Recent: []HeadToHeadGame{}}
+	err := r.queryRow(`
+	SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN winner = $1 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN winner = 'draw' THEN 1 ELSE 0 END), 0)
+	FROM games
+	WHERE `+pairFilter, player, opponent).Scan(&h.Games, &h.Wins, &h.Draws)
+	if err != nil {
+		return nil, err
+	}
+	h.finish()
+	if h.Games == 0 {
+		return h, nil
+	}
+
+	rows, err := r.query(`
+	SELECT `+gameColumns+` FROM games
+	WHERE `+pairFilter+`
+	ORDER BY finished_at DESC, game_id
+	LIMIT $3 OFFSET $4
+	`, player, opponent, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		sg, err := scanGame(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		h.Recent = append(h.Recent, HeadToHeadGame{StoredGame: sg})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// rating_history_game_idx finds each game's rows
+	rows, err = r.query(`
+	SELECT rh.game_id, rh.username, rh.rating_before, rh.rating_after
+	FROM games g JOIN rating_history rh ON rh.game_id = g.game_id
+	WHERE ((g.player1 = $1 AND g.player2 = $2) OR (g.player1 = $2 AND g.player2 = $1))
+		AND rh.username IN ($1, $2)
+	`, player, opponent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratings []h2hRating
+	for rows.Next() {
+		var rh h2hRating
+		if err := rows.Scan(&rh.gameID, &rh.username, &rh.before, &rh.after); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	h.addRatings(ratings)
+	return h, nil
+}
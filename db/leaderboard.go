@@ -0,0 +1,210 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Leaderboard metrics
+const (
+	MetricRating  = "rating"
+	MetricWins    = "wins"
+	MetricWinRate = "win-rate"
+)
+
+// Leaderboard modes: which games count
+const (
+	ModeAll = "all"
+	ModePvP = "pvp"
+	ModeBot = "bot"
+)
+
+// LeaderboardSize is how many players a page holds by default
+const LeaderboardSize = 10
+
+// LeaderboardQuery selects which games count and how players are ranked.
+// Players are ranked by the metric, then by username, and need at least
+// MinGames counted games to appear.
+type LeaderboardQuery struct {
+	Since    time.Time // only games finished since, from the start of its UTC day; zero for all time
+	Mode     string    // ModePvP, ModeBot or ModeAll
+	Variant  string    // only this variant; empty for any
+	Metric   string    // MetricRating, MetricWins or MetricWinRate
+	MinGames int
+	Limit    int
+	Offset   int
+}
+
+type LeaderboardEntry struct {
+	Rank      int     `json:"rank"`
+	Username  string  `json:"username"`
+	Rating    float64 `json:"rating"`
+	TotalWins int     `json:"total_wins"` // within the query's games
+	Games     int     `json:"games"`
+	WinRate   float64 `json:"win_rate"`
+}
+
+func (e *LeaderboardEntry) finish() {
+	if e.Games > 0 {
+		e.WinRate = float64(e.TotalWins) / float64(e.Games)
+	}
+}
+
+// leaderboardOrder is the ranking for each metric, best first
+var leaderboardOrder = map[string]string{
+	MetricRating:  `rating DESC, username`,
+	MetricWins:    `wins DESC, games, username`,
+	MetricWinRate: `wins * 1.0 / games DESC, games DESC, username`,
+}
+
+// leaderboardSQL ranks every qualifying player from player_stats; with
+// username set it keeps only that player, otherwise it returns the
+// q.Limit/q.Offset page.
+func leaderboardSQL(q LeaderboardQuery, username string) (string, []interface{}, error) {
+	order, ok := leaderboardOrder[q.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown leaderboard metric %q", q.Metric)
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	filters := []string{"TRUE"}
+	if !q.Since.IsZero() {
+		filters = append(filters, "day >= "+arg(statsDay(q.Since)))
+	}
+	switch q.Mode {
+	case ModePvP:
+		filters = append(filters, "NOT bot_game")
+	case ModeBot:
+		filters = append(filters, "bot_game")
+	}
+	if q.Variant != "" {
+		filters = append(filters, "variant = "+arg(q.Variant))
+	}
+
+	query := `
+	WITH totals AS (
+		SELECT username, SUM(games) AS games, SUM(wins) AS wins
+		FROM player_stats
+		WHERE ` + strings.Join(filters, " AND ") + `
+		GROUP BY username
+		HAVING SUM(games) >= ` + arg(max(q.MinGames, 1)) + `
+	), ranked AS (
+		SELECT t.username, COALESCE(pr.rating, ` + arg(DefaultRating) + `) AS rating, t.wins, t.games
+		FROM totals t LEFT JOIN player_ratings pr ON pr.username = t.username
+	), positions AS (
+		SELECT ROW_NUMBER() OVER (ORDER BY ` + order + `) AS pos, username, rating, wins, games
+		FROM ranked
+	)
+	SELECT pos, username, rating, wins, games FROM positions`
+	if username != "" {
+		query += `
+	WHERE username = ` + arg(username)
+	} else {
+		query += `
+	ORDER BY pos
+	LIMIT ` + arg(q.Limit) + ` OFFSET ` + arg(q.Offset)
+	}
+	return query, args, nil
+}
+
+func (r *SQLRepository) GetLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error) {
+	query, args, err := leaderboardSQL(q, "")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []LeaderboardEntry
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.Rank, &e.Username, &e.Rating, &e.TotalWins, &e.Games); err != nil {
+			return nil, err
+		}
+		e.finish()
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+func (r *SQLRepository) GetLeaderboardPosition(q LeaderboardQuery, username string) (*LeaderboardEntry, error) {
+	query, args, err := leaderboardSQL(q, username)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var e LeaderboardEntry
+	if err := rows.Scan(&e.Rank, &e.Username, &e.Rating, &e.TotalWins, &e.Games); err != nil {
+		return nil, err
+	}
+	e.finish()
+	return &e, nil
+}
+
+// rankLeaderboard is leaderboardSQL for in-memory rows: it filters by
+// MinGames, sorts by the metric and numbers the entries
+func rankLeaderboard(q LeaderboardQuery, entries []LeaderboardEntry) ([]LeaderboardEntry, error) {
+	var less func(a, b *LeaderboardEntry) bool
+	switch q.Metric {
+	case MetricRating:
+		less = func(a, b *LeaderboardEntry) bool {
+			if a.Rating != b.Rating {
+				return a.Rating > b.Rating
+			}
+			return a.Username < b.Username
+		}
+	case MetricWins:
+		less = func(a, b *LeaderboardEntry) bool {
+			if a.TotalWins != b.TotalWins {
+				return a.TotalWins > b.TotalWins
+			}
+			if a.Games != b.Games {
+				return a.Games < b.Games
+			}
+			return a.Username < b.Username
+		}
+	case MetricWinRate:
+		less = func(a, b *LeaderboardEntry) bool {
+			if a.WinRate != b.WinRate {
+				return a.WinRate > b.WinRate
+			}
+			if a.Games != b.Games {
+				return a.Games > b.Games
+			}
+			return a.Username < b.Username
+		}
+	default:
+		return nil, fmt.Errorf("unknown leaderboard metric %q", q.Metric)
+	}
+
+	var res []LeaderboardEntry
+	for _, e := range entries {
+		if e.Games >= max(q.MinGames, 1) {
+			e.finish()
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return less(&res[i], &res[j]) })
+	for i := range res {
+		res[i].Rank = i + 1
+	}
+	return res, nil
+}
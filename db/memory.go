@@ -0,0 +1,648 @@
+package db
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/game"
+	"fourinrow/rating"
+)
+
+// MemoryRepository keeps everything in process memory. It behaves like the
+// SQL repository but loses its data on restart, so it suits tests and
+// running locally without a database.
+type MemoryRepository struct {
+	mu        sync.Mutex
+	games     map[string]*StoredGame
+	moves     map[string][]StoredMove
+	stats     map[statsKey]statsCount // player_stats
+	ratings   map[string]*memoryRating
+	history   map[string][]RatingHistoryEntry // per player, oldest first
+	guests    map[string]*Guest
+	accounts  map[string]*Account
+	blocks    map[[2]string]bool
+	snapshots map[string][]byte
+	outbox    []*outboxRow // oldest first
+	outboxIDs map[string]*outboxRow
+}
+
+// memoryOutboxLimit caps the memory outbox, which nothing persists anyway:
+// past it the oldest rows are dropped, sent or not
+const memoryOutboxLimit = 10000
+
+type outboxRow struct {
+	event        analytics.GameEvent
+	createdAt    time.Time
+	sentAt       *time.Time
+	claimedUntil time.Time
+	attempts     int
+	lastError    string
+}
+
+type memoryRating struct {
+	rating.Rating
+	games int
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		games:     make(map[string]*StoredGame),
+		moves:     make(map[string][]StoredMove),
+		stats:     make(map[statsKey]statsCount),
+		ratings:   make(map[string]*memoryRating),
+		history:   make(map[string][]RatingHistoryEntry),
+		guests:    make(map[string]*Guest),
+		accounts:  make(map[string]*Account),
+		blocks:    make(map[[2]string]bool),
+		snapshots: make(map[string][]byte),
+		outboxIDs: make(map[string]*outboxRow),
+	}
+}
+
+func (r *MemoryRepository) Close() error { return nil }
+
+func (r *MemoryRepository) SaveGame(g *game.Game) (map[string]game.RatingChange, error) {
+	now := time.Now()
+	row, p1, p2, ok := gameRow(g, now)
+	if !ok {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resave := r.games[g.ID] != nil
+	if sg := r.games[g.ID]; sg != nil {
+		// Resaving keeps the players, variant, start and series
+		countGame(r.stats, sg, -1)
+		sg.Winner, sg.EndReason, sg.MoveCount, sg.DurationMs, sg.FinishedAt =
+			row.Winner, row.EndReason, row.MoveCount, row.DurationMs, row.FinishedAt
+	} else {
+		r.games[g.ID] = &row
+	}
+	countGame(r.stats, r.games[g.ID], 1)
+	r.addToOutbox(gameFinishedEvent(g, now), now)
+	r.saveMoves(g.ID, gameMoves(g))
+
+	if g.Series != nil {
+		for _, id := range g.Series.GameIDs {
+			if sg := r.games[id]; sg != nil {
+				sg.SeriesID = g.Series.ID
+			}
+		}
+	}
+
+	// Bot games are never rated, otherwise farming the bot pays off. Only
+	// the first save rates a game; a resave reports what that one did.
+	if p1.IsBot || p2.IsBot {
+		return nil, nil
+	}
+	if resave {
+		return r.gameRatingChanges(g.ID, p1.Username, p2.Username), nil
+	}
+	before1, before2 := r.rating(p1.Username).Rating, r.rating(p2.Username).Rating
+	after1, after2 := rating.Default.Rate(before1, before2, scoreFor(row.Winner, p1.Username, p2.Username))
+	r.saveRating(p1.Username, g.ID, before1, after1, now)
+	r.saveRating(p2.Username, g.ID, before2, after2, now)
+
+	return map[string]game.RatingChange{
+		p1.Username: {Before: before1.Value, After: after1.Value, Delta: after1.Value - before1.Value},
+		p2.Username: {Before: before2.Value, After: after2.Value, Delta: after2.Value - before2.Value},
+	}, nil
+}
+
+// saveMoves adds moves not stored yet, like ON CONFLICT DO NOTHING
+func (r *MemoryRepository) saveMoves(gameID string, moves []StoredMove) {
+	have := make(map[int]bool)
+	for _, m := range r.moves[gameID] {
+		have[m.Ply] = true
+	}
+	for _, m := range moves {
+		if !have[m.Ply] {
+			r.moves[gameID] = append(r.moves[gameID], m)
+		}
+	}
+	sort.Slice(r.moves[gameID], func(i, j int) bool { return r.moves[gameID][i].Ply < r.moves[gameID][j].Ply })
+}
+
+func (r *MemoryRepository) GetMoves(gameID string) ([]StoredMove, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]StoredMove(nil), r.moves[gameID]...), nil
+}
+
+// rating returns the player's rating row, creating it if needed
+func (r *MemoryRepository) rating(username string) *memoryRating {
+	cur := r.ratings[username]
+	if cur == nil {
+		cur = &memoryRating{Rating: rating.New()}
+		r.ratings[username] = cur
+	}
+	return cur
+}
+
+func (r *MemoryRepository) saveRating(username, gameID string, before, after rating.Rating, now time.Time) {
+	cur := r.rating(username)
+	cur.Rating = after
+	cur.games++
+	r.history[username] = append(r.history[username], RatingHistoryEntry{
+		GameID: gameID, RatingBefore: before.Value, RatingAfter: after.Value, CreatedAt: now,
+	})
+}
+
+// gameRatingChanges is what rating gameID already gave each of usernames
+func (r *MemoryRepository) gameRatingChanges(gameID string, usernames ...string) map[string]game.RatingChange {
+	var changes map[string]game.RatingChange
+	for _, u := range usernames {
+		for _, e := range r.history[u] {
+			if e.GameID != gameID {
+				continue
+			}
+			if changes == nil {
+				changes = make(map[string]game.RatingChange)
+			}
+			changes[u] = game.RatingChange{Before: e.RatingBefore, After: e.RatingAfter, Delta: e.RatingAfter - e.RatingBefore}
+		}
+	}
+	return changes
+}
+
+func (r *MemoryRepository) GetGame(id string) (*StoredGame, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sg := r.games[id]
+	if sg == nil {
+		return nil, ErrGameNotFound
+	}
+	cp := *sg
+	return &cp, nil
+}
+
+func (r *MemoryRepository) ListGamesForPlayer(username string, limit, offset int) ([]StoredGame, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []StoredGame
+	for _, sg := range r.games {
+		if sg.Player1 == username || sg.Player2 == username {
+			res = append(res, *sg)
+		}
+	}
+	sortGames(res)
+	return page(res, limit, offset), nil
+}
+
+// sortGames orders games newest first, like ORDER BY finished_at DESC, game_id
+func sortGames(games []StoredGame) {
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].FinishedAt.Equal(games[j].FinishedAt) {
+			return games[i].FinishedAt.After(games[j].FinishedAt)
+		}
+		return games[i].ID < games[j].ID
+	})
+}
+
+// between reports whether sg was played by a and b, in either seat
+func between(sg *StoredGame, a, b string) bool {
+	return (sg.Player1 == a && sg.Player2 == b) || (sg.Player1 == b && sg.Player2 == a)
+}
+
+// page cuts one LIMIT/OFFSET page out of items
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (r *MemoryRepository) GetPlayerStats(username string) (*PlayerStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var games []*StoredGame
+	buckets := make(map[[2]bool]*statsGroup)
+	for _, sg := range r.games {
+		if sg.Player1 != username && sg.Player2 != username {
+			continue
+		}
+		games = append(games, sg)
+		key := [2]bool{sg.Player1 == username, sg.BotGame}
+		g := buckets[key]
+		if g == nil {
+			g = &statsGroup{player1: key[0], botGame: key[1]}
+			buckets[key] = g
+		}
+		g.results.Games++
+		switch sg.Winner {
+		case username:
+			g.results.Wins++
+		case "draw":
+			g.results.Draws++
+		}
+		g.moves += int64(sg.MoveCount)
+		g.duration += sg.DurationMs
+	}
+	var groups []statsGroup
+	for _, g := range buckets {
+		groups = append(groups, *g)
+	}
+	s := buildStats(username, groups)
+
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].FinishedAt.Equal(games[j].FinishedAt) {
+			return games[i].FinishedAt.Before(games[j].FinishedAt)
+		}
+		return games[i].ID < games[j].ID
+	})
+	streak := 0
+	for _, sg := range games {
+		if sg.Winner == username {
+			streak++
+			s.LongestWinStreak = max(s.LongestWinStreak, streak)
+		} else {
+			streak = 0
+		}
+	}
+
+	openings := make(map[int]int)
+	for _, moves := range r.moves {
+		if len(moves) > 0 && moves[0].Ply == 1 && moves[0].Player == username {
+			openings[moves[0].Column]++
+		}
+	}
+	for column, n := range openings {
+		if s.FavoriteOpening == nil || n > openings[*s.FavoriteOpening] || (n == openings[*s.FavoriteOpening] && column < *s.FavoriteOpening) {
+			c := column
+			s.FavoriteOpening = &c
+		}
+	}
+	return s, nil
+}
+
+// leaderboard ranks everyone who qualifies for q
+func (r *MemoryRepository) leaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error) {
+	totals := make(map[string]*LeaderboardEntry)
+	for k, c := range r.stats {
+		if (!q.Since.IsZero() && k.day < statsDay(q.Since)) ||
+			(q.Mode == ModePvP && k.botGame) || (q.Mode == ModeBot && !k.botGame) ||
+			(q.Variant != "" && k.variant != q.Variant) {
+			continue
+		}
+		e := totals[k.username]
+		if e == nil {
+			e = &LeaderboardEntry{Username: k.username, Rating: DefaultRating}
+			if cur := r.ratings[k.username]; cur != nil {
+				e.Rating = cur.Value
+			}
+			totals[k.username] = e
+		}
+		e.Games += c.games
+		e.TotalWins += c.wins
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(totals))
+	for _, e := range totals {
+		entries = append(entries, *e)
+	}
+	return rankLeaderboard(q, entries)
+}
+
+func (r *MemoryRepository) RebuildPlayerStats() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rebuildStats()
+	return len(r.games), nil
+}
+
+func (r *MemoryRepository) rebuildStats() {
+	r.stats = make(map[statsKey]statsCount)
+	for _, sg := range r.games {
+		countGame(r.stats, sg, 1)
+	}
+}
+
+func (r *MemoryRepository) GetLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	board, err := r.leaderboard(q)
+	if err != nil {
+		return nil, err
+	}
+	return page(board, q.Limit, q.Offset), nil
+}
+
+func (r *MemoryRepository) GetLeaderboardPosition(q LeaderboardQuery, username string) (*LeaderboardEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	board, err := r.leaderboard(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range board {
+		if e.Username == username {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) GetHeadToHead(player, opponent string, limit, offset int) (*HeadToHead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h := &HeadToHead{Player: player, Opponent: opponent}
+	var games []StoredGame
+	for _, sg := range r.games {
+		if between(sg, player, opponent) {
+			games = append(games, *sg)
+			h.Games++
+			if sg.Winner == player {
+				h.Wins++
+			} else if sg.Winner == "draw" {
+				h.Draws++
+			}
+		}
+	}
+	h.finish()
+	sortGames(games)
+	for _, sg := range page(games, limit, offset) {
+		h.Recent = append(h.Recent, HeadToHeadGame{StoredGame: sg})
+	}
+	if h.Recent == nil {
+		h.Recent = []HeadToHeadGame{}
+	}
+
+	var ratings []h2hRating
+	for _, username := range []string{player, opponent} {
+		for _, e := range r.history[username] {
+			if sg := r.games[e.GameID]; sg != nil && between(sg, player, opponent) {
+				ratings = append(ratings, h2hRating{gameID: e.GameID, username: username, before: e.RatingBefore, after: e.RatingAfter})
+			}
+		}
+	}
+	h.addRatings(ratings)
+	return h, nil
+}
+
+func (r *MemoryRepository) GetRating(username string) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cur := r.ratings[username]; cur != nil {
+		return cur.Value, nil
+	}
+	return DefaultRating, nil
+}
+
+func (r *MemoryRepository) GetRatingHistory(username string, limit int) ([]RatingHistoryEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := r.history[username]
+	res := make([]RatingHistoryEntry, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		res = append(res, history[i])
+	}
+	return page(res, limit, 0), nil
+}
+
+func (r *MemoryRepository) SaveGuest(id, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.guests[id] != nil {
+		return nil
+	}
+	for _, g := range r.guests {
+		if g.Username == username {
+			return ErrGuestNameTaken
+		}
+	}
+	r.guests[id] = &Guest{ID: id, Username: username, CreatedAt: time.Now()}
+	return nil
+}
+
+func (r *MemoryRepository) GetGuest(id string) (*Guest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := r.guests[id]
+	if g == nil {
+		return nil, ErrGuestNotFound
+	}
+	cp := *g
+	return &cp, nil
+}
+
+func (r *MemoryRepository) CreateAccount(username, passwordHash, guestID string) (*Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.accounts[username] != nil {
+		return nil, ErrAccountExists
+	}
+	// Check the guest first: a failed merge must not leave the account behind
+	var guest *Guest
+	if guestID != "" {
+		if guest = r.guests[guestID]; guest == nil {
+			return nil, ErrGuestNotFound
+		}
+	}
+
+	account := &Account{Username: username, CreatedAt: time.Now()}
+	r.accounts[username] = account
+	if guest != nil && guest.UpgradedTo == "" {
+		account.MergedGames = r.mergeGuest(guest.Username, username)
+	}
+	cp := *account
+	return &cp, nil
+}
+
+// mergeGuest moves the guest's games and rating to the account, as the SQL
+// repository's mergeGuest does, and returns how many games moved
+func (r *MemoryRepository) mergeGuest(guestName, username string) int {
+	games := 0
+	for _, sg := range r.games {
+		if sg.Player1 == guestName {
+			sg.Player1 = username
+			games++
+		}
+		if sg.Player2 == guestName {
+			sg.Player2 = username
+			games++
+		}
+		if sg.Winner == guestName {
+			sg.Winner = username
+		}
+	}
+	for _, moves := range r.moves {
+		for i := range moves {
+			if moves[i].Player == guestName {
+				moves[i].Player = username
+			}
+		}
+	}
+	r.rebuildStats()
+	if cur := r.ratings[guestName]; cur != nil && r.ratings[username] == nil {
+		r.ratings[username] = cur
+		delete(r.ratings, guestName)
+	}
+	for _, g := range r.guests {
+		if g.Username == guestName {
+			g.UpgradedTo = username
+		}
+	}
+	return games
+}
+
+// SaveChatMessage does nothing: the memory repository keeps no chat logs
+func (r *MemoryRepository) SaveChatMessage(gameID string, m game.ChatMessage) error {
+	return nil
+}
+
+func (r *MemoryRepository) SetBlocked(username, target string, on bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if on {
+		r.blocks[[2]string{username, target}] = true
+	} else {
+		delete(r.blocks, [2]string{username, target})
+	}
+	return nil
+}
+
+func (r *MemoryRepository) IsBlocked(username, target string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.blocks[[2]string{username, target}], nil
+}
+
+func (r *MemoryRepository) SaveSnapshot(g *game.Game) error {
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshots[g.ID] = data
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSnapshot(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.snapshots, id)
+	return nil
+}
+
+func (r *MemoryRepository) LoadSnapshots() ([]*game.Game, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var games []*game.Game
+	for _, data := range r.snapshots {
+		if g, err := game.DecodeGame(data); err == nil {
+			games = append(games, g)
+		}
+	}
+	return games, nil
+}
+
+// addToOutbox queues event, replacing it if it is still waiting, like the
+// SQL upsert
+func (r *MemoryRepository) addToOutbox(event analytics.GameEvent, now time.Time) {
+	if row := r.outboxIDs[event.EventID]; row != nil {
+		if row.sentAt == nil {
+			row.event = event
+		}
+		return
+	}
+	row := &outboxRow{event: event, createdAt: now}
+	r.outbox = append(r.outbox, row)
+	r.outboxIDs[event.EventID] = row
+
+	for len(r.outbox) > memoryOutboxLimit {
+		old := r.outbox[0]
+		r.outbox = r.outbox[1:]
+		delete(r.outboxIDs, old.event.EventID)
+		if old.sentAt == nil {
+			log.Printf("[OUTBOX] Memory outbox full, dropped unsent %s", old.event.EventID)
+		}
+	}
+}
+
+func (r *MemoryRepository) ClaimEvents(limit int, lease time.Duration) ([]analytics.GameEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var res []analytics.GameEvent
+	for _, row := range r.outbox {
+		if len(res) == limit {
+			break
+		}
+		if row.sentAt == nil && !now.Before(row.claimedUntil) {
+			row.claimedUntil = now.Add(lease)
+			res = append(res, row.event)
+		}
+	}
+	return res, nil
+}
+
+func (r *MemoryRepository) MarkEventsSent(ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sent := make(map[string]bool)
+	for _, id := range ids {
+		sent[id] = true
+	}
+	kept := r.outbox[:0]
+	for _, row := range r.outbox {
+		if sent[row.event.EventID] && row.sentAt == nil {
+			row.sentAt = &now
+		}
+		if row.sentAt == nil || now.Sub(*row.sentAt) < outboxRetention {
+			kept = append(kept, row)
+		} else {
+			delete(r.outboxIDs, row.event.EventID)
+		}
+	}
+	r.outbox = kept
+	return nil
+}
+
+func (r *MemoryRepository) MarkEventsFailed(ids []string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failed := make(map[string]bool)
+	for _, id := range ids {
+		failed[id] = true
+	}
+	for _, row := range r.outbox {
+		if failed[row.event.EventID] {
+			row.attempts++
+			row.lastError = reason
+			row.claimedUntil = time.Time{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations live in migrations/DIALECT/NNNN_name.up.sql with an optional
+// matching .down.sql. Versions only ever grow; never edit a migration once
+// released. Both dialects keep the same version numbers.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrNoDownMigration = errors.New("migration has no down script")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // empty if the migration can't be reverted
+}
+
+// MigrationStatus is one migration and whether it has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the embedded migrations for d, ordered by version
+func LoadMigrations(d *Dialect) ([]Migration, error) {
+	return loadMigrations(migrationFiles, d.migrations)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, dir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations, recording them in schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	dialect    *Dialect
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, d *Dialect) (*Migrator, error) {
+	migrations, err := LoadMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order and returns how many ran
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies pending migrations up to and including version (0 = all)
+func (m *Migrator) UpTo(ctx context.Context, version int) (int, error) {
+	ran := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, mig := range m.migrations {
+			if version > 0 && mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			ran++
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down reverts the most recently applied steps migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	ran := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && ran < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("%d_%s: %w", mig.Version, mig.Name, ErrNoDownMigration)
+			}
+			if err := m.apply(ctx, conn, mig, false); err != nil {
+				return err
+			}
+			ran++
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Status lists every known migration with when it was applied, if ever
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, mig := range m.migrations {
+			s := MigrationStatus{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			status = append(status, s)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// locked runs fn on one connection while holding the migration lock, with
+// the versions applied so far
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, so lock and unlock on this conn.
+	// SQLite has none; its single writer is enough for a local file.
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.dialect.unlock)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// apply runs one migration and its bookkeeping in a single transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, direction := mig.Up, "up"
+	if !up {
+		script, direction = mig.Down, "down"
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`),
+			mig.Version, mig.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.rebind(`DELETE FROM schema_migrations WHERE version = $1`), mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[MIGRATE] %s %d_%s", direction, mig.Version, mig.Name)
+	return nil
+}
@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"fourinrow/db"
+)
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	pg, err := db.LoadMigrations(db.Postgres)
+	if err != nil {
+		t.Fatalf("postgres: %v", err)
+	}
+	lite, err := db.LoadMigrations(db.SQLite)
+	if err != nil {
+		t.Fatalf("sqlite: %v", err)
+	}
+	if len(pg) != len(lite) {
+		t.Fatalf("%d postgres migrations, %d sqlite", len(pg), len(lite))
+	}
+	for i := range pg {
+		if pg[i].Version != i+1 || lite[i].Version != i+1 || pg[i].Name != lite[i].Name {
+			t.Errorf("migration %d: postgres %d_%s, sqlite %d_%s", i+1, pg[i].Version, pg[i].Name, lite[i].Version, lite[i].Name)
+		}
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	conn, dialect, err := db.Connect("sqlite:" + filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	m, err := db.NewMigrator(conn, dialect)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	all, _ := db.LoadMigrations(dialect)
+
+	// A partial run applies exactly the first versions
+	if ran, err := m.UpTo(ctx, 3); err != nil || ran != 3 {
+		t.Fatalf("UpTo(3) = %d, %v", ran, err)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range status {
+		if applied := s.AppliedAt != nil; applied != (s.Version <= 3) {
+			t.Errorf("after UpTo(3), %d_%s applied = %v", s.Version, s.Name, applied)
+		}
+	}
+
+	// The rest follow in version order
+	if ran, err := m.Up(ctx); err != nil || ran != len(all)-3 {
+		t.Fatalf("Up = %d, %v; want %d", ran, err, len(all)-3)
+	}
+	rows, err := conn.Query(`SELECT version FROM schema_migrations ORDER BY applied_at, version`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	var order []int
+	for rows.Next() {
+		var v int
+		rows.Scan(&v)
+		order = append(order, v)
+	}
+	rows.Close()
+	if len(order) != len(all) {
+		t.Fatalf("%d migrations recorded, want %d", len(order), len(all))
+	}
+	for i, v := range order {
+		if v != all[i].Version {
+			t.Fatalf("applied in order %v", order)
+		}
+	}
+
+	// Running again is a no-op
+	if ran, err := m.Up(ctx); err != nil || ran != 0 {
+		t.Fatalf("second Up = %d, %v; want nothing to run", ran, err)
+	}
+
+	// A reverted migration is pending again and reapplies cleanly
+	if ran, err := m.Down(ctx, 2); err != nil || ran != 2 {
+		t.Fatalf("Down(2) = %d, %v", ran, err)
+	}
+	if ran, err := m.Up(ctx); err != nil || ran != 2 {
+		t.Fatalf("Up after Down = %d, %v; want 2", ran, err)
+	}
+}
@@ -0,0 +1,14 @@
+-- Guests are anonymous players identified by a signed cookie; accounts
+-- are registered players. A guest can be upgraded into an account once.
+CREATE TABLE IF NOT EXISTS guests (
+	guest_id TEXT PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP,
+	last_seen TIMESTAMP,
+	upgraded_to TEXT
+);
+CREATE TABLE IF NOT EXISTS accounts (
+	username TEXT PRIMARY KEY,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP
+);
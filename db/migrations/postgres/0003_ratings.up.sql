@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS player_ratings (
+	username TEXT PRIMARY KEY,
+	rating DOUBLE PRECISION NOT NULL DEFAULT 1500,
+	updated_at TIMESTAMP
+);
+-- Glicko-2 columns, added separately so databases created before them upgrade
+ALTER TABLE player_ratings ADD COLUMN IF NOT EXISTS rd DOUBLE PRECISION NOT NULL DEFAULT 350;
+ALTER TABLE player_ratings ADD COLUMN IF NOT EXISTS volatility DOUBLE PRECISION NOT NULL DEFAULT 0.06;
+ALTER TABLE player_ratings ADD COLUMN IF NOT EXISTS games INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS rating_history (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	game_id TEXT NOT NULL,
+	rating_before DOUBLE PRECISION NOT NULL,
+	rating_after DOUBLE PRECISION NOT NULL,
+	rd_after DOUBLE PRECISION NOT NULL,
+	created_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS rating_history_username_idx ON rating_history (username, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS series;
+ALTER TABLE games DROP COLUMN IF EXISTS series_id;
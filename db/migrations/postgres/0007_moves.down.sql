@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moves;
+ALTER TABLE games DROP COLUMN IF EXISTS duration_ms;
+ALTER TABLE games DROP COLUMN IF EXISTS move_count;
+ALTER TABLE games DROP COLUMN IF EXISTS variant;
+ALTER TABLE games DROP COLUMN IF EXISTS end_reason;
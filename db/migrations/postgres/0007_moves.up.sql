@@ -0,0 +1,16 @@
+-- What SaveGame knows about how a game went, beyond who won
+ALTER TABLE games ADD COLUMN IF NOT EXISTS end_reason TEXT;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS variant TEXT;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS move_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0;
+-- Every disc dropped, written in the same transaction as the game row
+CREATE TABLE IF NOT EXISTS moves (
+	game_id TEXT NOT NULL,
+	ply INTEGER NOT NULL,
+	player TEXT NOT NULL,
+	column_index INTEGER NOT NULL,
+	row_index INTEGER NOT NULL,
+	played_at TIMESTAMP NOT NULL,
+	think_ms BIGINT NOT NULL,
+	PRIMARY KEY (game_id, ply)
+);
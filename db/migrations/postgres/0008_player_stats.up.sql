@@ -0,0 +1,9 @@
+-- Profiles split results by opponent type, so games record whether a bot played
+ALTER TABLE games ADD COLUMN IF NOT EXISTS bot_game BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE games SET bot_game = TRUE WHERE player1 = 'Bot 🤖' OR player2 = 'Bot 🤖';
+-- A player's games, newest first, from either seat
+CREATE INDEX IF NOT EXISTS games_player1_idx ON games (player1, finished_at);
+CREATE INDEX IF NOT EXISTS games_player2_idx ON games (player2, finished_at);
+CREATE INDEX IF NOT EXISTS games_winner_idx ON games (winner);
+-- Opening moves per player
+CREATE INDEX IF NOT EXISTS moves_player_idx ON moves (player, ply);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS rating_history_game_key;
+CREATE INDEX IF NOT EXISTS rating_history_game_idx ON rating_history (game_id, username);
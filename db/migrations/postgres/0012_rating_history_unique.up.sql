@@ -0,0 +1,10 @@
+-- Resaving a game used to rate it again. Keep each player's first change per
+-- game and recount their rated games; the extra rating movement itself can't
+-- be unpicked and settles out over later games.
+DELETE FROM rating_history
+WHERE id NOT IN (SELECT MIN(id) FROM rating_history GROUP BY username, game_id);
+UPDATE player_ratings
+SET games = (SELECT COUNT(*) FROM rating_history rh WHERE rh.username = player_ratings.username);
+-- One change per player per game; replaces the plain index from 0010
+DROP INDEX IF EXISTS rating_history_game_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS rating_history_game_key ON rating_history (game_id, username);
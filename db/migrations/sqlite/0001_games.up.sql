@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS games (
+	game_id TEXT PRIMARY KEY,
+	player1 TEXT,
+	player2 TEXT,
+	winner TEXT,
+	created_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS player_ratings (
+	username TEXT PRIMARY KEY,
+	rating REAL NOT NULL DEFAULT 1500,
+	rd REAL NOT NULL DEFAULT 350,
+	volatility REAL NOT NULL DEFAULT 0.06,
+	games INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS rating_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	game_id TEXT NOT NULL,
+	rating_before REAL NOT NULL,
+	rating_after REAL NOT NULL,
+	rd_after REAL NOT NULL,
+	created_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS rating_history_username_idx ON rating_history (username, created_at);
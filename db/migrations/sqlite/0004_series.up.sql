@@ -0,0 +1,12 @@
+-- Rematches between the same two players form a series
+ALTER TABLE games ADD COLUMN series_id TEXT;
+CREATE TABLE IF NOT EXISTS series (
+	series_id TEXT PRIMARY KEY,
+	player_a TEXT NOT NULL,
+	player_b TEXT NOT NULL,
+	wins_a INTEGER NOT NULL DEFAULT 0,
+	wins_b INTEGER NOT NULL DEFAULT 0,
+	draws INTEGER NOT NULL DEFAULT 0,
+	games INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	game_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	text TEXT NOT NULL,
+	sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS chat_messages_game_idx ON chat_messages (game_id);
+CREATE TABLE IF NOT EXISTS blocks (
+	username TEXT NOT NULL,
+	blocked TEXT NOT NULL,
+	PRIMARY KEY (username, blocked)
+);
@@ -0,0 +1,6 @@
+-- Snapshots of in-progress games, so a restart can resume them
+CREATE TABLE IF NOT EXISTS active_games (
+	game_id TEXT PRIMARY KEY,
+	state BLOB NOT NULL,
+	updated_at TIMESTAMP
+);
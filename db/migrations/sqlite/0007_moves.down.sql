@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moves;
+ALTER TABLE games DROP COLUMN duration_ms;
+ALTER TABLE games DROP COLUMN move_count;
+ALTER TABLE games DROP COLUMN variant;
+ALTER TABLE games DROP COLUMN end_reason;
@@ -0,0 +1,16 @@
+-- What SaveGame knows about how a game went, beyond who won
+ALTER TABLE games ADD COLUMN end_reason TEXT;
+ALTER TABLE games ADD COLUMN variant TEXT;
+ALTER TABLE games ADD COLUMN move_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
+-- Every disc dropped, written in the same transaction as the game row
+CREATE TABLE IF NOT EXISTS moves (
+	game_id TEXT NOT NULL,
+	ply INTEGER NOT NULL,
+	player TEXT NOT NULL,
+	column_index INTEGER NOT NULL,
+	row_index INTEGER NOT NULL,
+	played_at TIMESTAMP NOT NULL,
+	think_ms INTEGER NOT NULL,
+	PRIMARY KEY (game_id, ply)
+);
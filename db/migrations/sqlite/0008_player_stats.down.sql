@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS moves_player_idx;
+DROP INDEX IF EXISTS games_winner_idx;
+DROP INDEX IF EXISTS games_player2_idx;
+DROP INDEX IF EXISTS games_player1_idx;
+ALTER TABLE games DROP COLUMN bot_game;
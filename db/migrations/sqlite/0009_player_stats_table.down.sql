@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS player_stats_day_idx;
+DROP TABLE IF EXISTS player_stats;
@@ -0,0 +1,26 @@
+-- Leaderboard totals per player, day (UTC), opponent type and variant, kept
+-- up to date by SaveGame so the leaderboard never scans games
+CREATE TABLE IF NOT EXISTS player_stats (
+	username TEXT NOT NULL,
+	day TEXT NOT NULL,
+	bot_game BOOLEAN NOT NULL,
+	variant TEXT NOT NULL DEFAULT '',
+	games INTEGER NOT NULL DEFAULT 0,
+	wins INTEGER NOT NULL DEFAULT 0,
+	draws INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (username, day, bot_game, variant)
+);
+CREATE INDEX IF NOT EXISTS player_stats_day_idx ON player_stats (day);
+-- Backfill from existing games. The bot gets no rows; older
+-- games may have it in either seat.
+INSERT INTO player_stats (username, day, bot_game, variant, games, wins, draws)
+SELECT username, day, bot_game, variant, COUNT(*),
+	SUM(CASE WHEN winner = username THEN 1 ELSE 0 END),
+	SUM(CASE WHEN winner = 'draw' THEN 1 ELSE 0 END)
+FROM (
+	SELECT player1 AS username, date(finished_at) AS day, bot_game, COALESCE(variant, '') AS variant, winner FROM games
+	UNION ALL
+	SELECT player2, date(finished_at), bot_game, COALESCE(variant, ''), winner FROM games
+) results
+WHERE username <> 'Bot 🤖'
+GROUP BY username, day, bot_game, variant;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS rating_history_game_idx;
+DROP INDEX IF EXISTS games_pair_idx;
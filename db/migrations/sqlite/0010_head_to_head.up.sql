@@ -0,0 +1,4 @@
+-- Games between two players, newest first, from either seating
+CREATE INDEX IF NOT EXISTS games_pair_idx ON games (player1, player2, finished_at);
+-- Rating changes from a given game
+CREATE INDEX IF NOT EXISTS rating_history_game_idx ON rating_history (game_id, username);
@@ -0,0 +1,14 @@
+-- Analytics events written in the same transaction as the game they describe;
+-- the relay publishes pending rows to Kafka and stamps sent_at
+CREATE TABLE IF NOT EXISTS outbox (
+	event_id TEXT PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	game_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	sent_at TIMESTAMP,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+);
+-- Pending rows (sent_at NULL) oldest first, and sent rows due for pruning
+CREATE INDEX IF NOT EXISTS outbox_sent_idx ON outbox (sent_at, created_at);
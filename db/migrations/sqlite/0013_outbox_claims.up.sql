@@ -0,0 +1,3 @@
+-- Every instance runs a relay; a relay claims a batch until claimed_until so
+-- the others skip it
+ALTER TABLE outbox ADD COLUMN claimed_until TIMESTAMP;
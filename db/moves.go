@@ -0,0 +1,91 @@
+package db
+
+import (
+	"time"
+
+	"fourinrow/game"
+)
+
+// StoredMove is one row of the moves table
+type StoredMove struct {
+	Ply      int       `json:"ply"`
+	Player   string    `json:"player"` // username
+	Column   int       `json:"column"`
+	Row      int       `json:"row"`
+	PlayedAt time.Time `json:"playedAt"`
+	ThinkMs  int64     `json:"thinkMs"` // since the previous move, or the start for the first
+}
+
+// gameMoves converts g's moves to rows, resolving player IDs to usernames
+func gameMoves(g *game.Game) []StoredMove {
+	names := make(map[string]string, len(g.Players))
+	for _, p := range g.Players {
+		names[p.ID] = p.Username
+	}
+
+	moves := make([]StoredMove, 0, len(g.Moves))
+	last := g.CreatedAt
+	for _, m := range g.Moves {
+		player, ok := names[m.PlayerID]
+		if !ok {
+			player = m.PlayerID
+		}
+		var think int64
+		if !last.IsZero() && m.At.After(last) {
+			think = m.At.Sub(last).Milliseconds()
+		}
+		moves = append(moves, StoredMove{Ply: m.Ply, Player: player, Column: m.Column, Row: m.Row, PlayedAt: m.At, ThinkMs: think})
+		last = m.At
+	}
+	return moves
+}
+
+// gameTimes is when g started and ended, filling in now for whatever the
+// game didn't record
+func gameTimes(g *game.Game, now time.Time) (created, finished time.Time) {
+	created, finished = g.CreatedAt, g.FinishedAt
+	if finished.IsZero() {
+		finished = now
+	}
+	if created.IsZero() || created.After(finished) {
+		created = finished
+	}
+	return created, finished
+}
+
+func saveMoves(tx *dialectTx, gameID string, moves []StoredMove) error {
+	for _, m := range moves {
+		_, err := tx.Exec(`
+		INSERT INTO moves (game_id, ply, player, column_index, row_index, played_at, think_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (game_id, ply) DO NOTHING
+		`, gameID, m.Ply, m.Player, m.Column, m.Row, m.PlayedAt, m.ThinkMs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMoves returns a saved game's moves in the order they were played
+func (r *SQLRepository) GetMoves(gameID string) ([]StoredMove, error) {
+	rows, err := r.query(`
+	SELECT ply, player, column_index, row_index, played_at, think_ms FROM moves
+	WHERE game_id = $1
+	ORDER BY ply
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []StoredMove
+	for rows.Next() {
+		var m StoredMove
+		if err := rows.Scan(&m.Ply, &m.Player, &m.Column, &m.Row, &m.PlayedAt, &m.ThinkMs); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
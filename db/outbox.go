@@ -0,0 +1,127 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/game"
+)
+
+// Sent events are kept this long for debugging, then pruned
+const outboxRetention = 7 * 24 * time.Hour
+
+// gameFinishedEvent is the analytics event SaveGame puts in the outbox. Its ID
+// comes from the game, so a resave doesn't queue it twice and consumers can
+// drop the copies at-least-once delivery sometimes produces.
+func gameFinishedEvent(g *game.Game, now time.Time) analytics.GameEvent {
+	return analytics.GameEvent{
+		EventID:   "game_finished:" + g.ID,
+		Type:      "game_finished",
+		GameID:    g.ID,
+		PlayerID:  g.Winner,
+		Timestamp: now.Unix(),
+		Payload:   g.Winner,
+	}
+}
+
+func addToOutbox(tx *dialectTx, event analytics.GameEvent, now time.Time) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// A resave that changed the result replaces an event still waiting; one
+	// already sent stays sent
+	_, err = tx.Exec(`
+	INSERT INTO outbox (event_id, event_type, game_id, payload, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (event_id) DO UPDATE SET payload = excluded.payload
+	WHERE outbox.sent_at IS NULL
+	`, event.EventID, event.Type, event.GameID, string(payload), now)
+	return err
+}
+
+func (r *SQLRepository) ClaimEvents(limit int, lease time.Duration) ([]analytics.GameEvent, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED lets relays on other instances claim the rows after ours
+	// instead of queueing behind us
+	now := time.Now()
+	rows, err := tx.Query(`
+	SELECT event_id, payload FROM outbox
+	WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < $1)
+	ORDER BY created_at, event_id
+	LIMIT $2 FOR UPDATE SKIP LOCKED
+	`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	var res []analytics.GameEvent
+	for rows.Next() {
+		var id, payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var e analytics.GameEvent
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		res = append(res, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`UPDATE outbox SET claimed_until = $2 WHERE event_id = $1`, id, now.Add(lease)); err != nil {
+			return nil, err
+		}
+	}
+	return res, tx.Commit()
+}
+
+func (r *SQLRepository) MarkEventsSent(ids []string) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, id := range ids {
+		if _, err := tx.Exec(`UPDATE outbox SET sent_at = $2 WHERE event_id = $1`, id, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE sent_at < $1`, now.Add(-outboxRetention)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLRepository) MarkEventsFailed(ids []string, reason string) error {
+	tx, err := r.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		_, err := tx.Exec(`
+		UPDATE outbox SET attempts = attempts + 1, last_error = $2, claimed_until = NULL WHERE event_id = $1
+		`, id, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
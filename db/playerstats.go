@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fourinrow/game"
+)
+
+// statsKey is one player_stats row: a player's games of one kind on one UTC
+// day. Leaderboard windows start at midnight UTC, so summing whole days
+// answers any of them.
+type statsKey struct {
+	username string
+	day      string // YYYY-MM-DD
+	botGame  bool
+	variant  string
+}
+
+type statsCount struct{ games, wins, draws int }
+
+// statsDay is the player_stats day a game finishing at t counts on
+func statsDay(t time.Time) string { return t.UTC().Format(time.DateOnly) }
+
+// countGame adds sign (1 or -1) times sg's result to each player's row. The
+// bot gets no row; older games may have it in either seat.
+func countGame(counts map[statsKey]statsCount, sg *StoredGame, sign int) {
+	for _, p := range []string{sg.Player1, sg.Player2} {
+		if p == game.BotUsername {
+			continue
+		}
+		k := statsKey{username: p, day: statsDay(sg.FinishedAt), botGame: sg.BotGame, variant: sg.Variant}
+		c := counts[k]
+		c.games += sign
+		if sg.Winner == p {
+			c.wins += sign
+		} else if sg.Winner == "draw" {
+			c.draws += sign
+		}
+		counts[k] = c
+	}
+}
+
+// sortedStatsKeys orders the rows so concurrent saves lock them in the same order
+func sortedStatsKeys(counts map[statsKey]statsCount) []statsKey {
+	keys := make([]statsKey, 0, len(counts))
+	for k, c := range counts {
+		if c != (statsCount{}) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.username != b.username {
+			return a.username < b.username
+		}
+		if a.day != b.day {
+			return a.day < b.day
+		}
+		if a.botGame != b.botGame {
+			return !a.botGame
+		}
+		return a.variant < b.variant
+	})
+	return keys
+}
+
+// addStats adds counts onto the player_stats rows
+func addStats(tx *dialectTx, counts map[statsKey]statsCount) error {
+	for _, k := range sortedStatsKeys(counts) {
+		c := counts[k]
+		_, err := tx.Exec(`
+		INSERT INTO player_stats (username, day, bot_game, variant, games, wins, draws)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (username, day, bot_game, variant) DO UPDATE SET
+			games = player_stats.games + $5, wins = player_stats.wins + $6, draws = player_stats.draws + $7
+		`, k.username, k.day, k.botGame, k.variant, c.games, c.wins, c.draws)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateStats moves a saved game's result in player_stats from old (nil for
+// a new game) to sg
+func updateStats(tx *dialectTx, old, sg *StoredGame) error {
+	counts := make(map[statsKey]statsCount)
+	if old != nil {
+		countGame(counts, old, -1)
+	}
+	countGame(counts, sg, 1)
+	return addStats(tx, counts)
+}
+
+// rebuildStats recomputes player_stats from the games table, for everyone or
+// only for usernames. It returns how many games it counted.
+func rebuildStats(tx *dialectTx, usernames ...string) (int, error) {
+	where, del := "", `DELETE FROM player_stats`
+	var args []interface{}
+	if len(usernames) > 0 {
+		var in []string
+		for _, u := range usernames {
+			args = append(args, u)
+			in = append(in, fmt.Sprintf("$%d", len(args)))
+		}
+		set := strings.Join(in, ", ")
+		where = ` WHERE player1 IN (` + set + `) OR player2 IN (` + set + `)`
+		del += ` WHERE username IN (` + set + `)`
+	}
+	if _, err := tx.Exec(del, args...); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`SELECT `+gameColumns+` FROM games`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	counts := make(map[statsKey]statsCount)
+	n := 0
+	for rows.Next() {
+		sg, err := scanGame(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		countGame(counts, &sg, 1)
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Opponents outside usernames keep their rows as they are
+	if len(usernames) > 0 {
+		keep := make(map[string]bool)
+		for _, u := range usernames {
+			keep[u] = true
+		}
+		for k := range counts {
+			if !keep[k.username] {
+				delete(counts, k)
+			}
+		}
+	}
+	return n, addStats(tx, counts)
+}
+
+func (r *SQLRepository) RebuildPlayerStats() (int, error) {
+	tx, err := r.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := rebuildStats(tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/rating"
+)
+
+// DefaultRating is what every player starts on before their first rated game
+const DefaultRating = rating.DefaultValue
+
+type RatingHistoryEntry struct {
+	GameID       string    `json:"gameId"`
+	RatingBefore float64   `json:"ratingBefore"`
+	RatingAfter  float64   `json:"ratingAfter"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// GetRating returns the player's current rating, or DefaultRating if they
+// have never been rated
+func (r *SQLRepository) GetRating(username string) (float64, error) {
+	var value float64
+	err := r.queryRow(`SELECT rating FROM player_ratings WHERE username = $1`, username).Scan(&value)
+	if err == sql.ErrNoRows {
+		return DefaultRating, nil
+	}
+	if err != nil {
+		return DefaultRating, err
+	}
+	return value, nil
+}
+
+// GetRatingHistory returns the player's rating after each rated game, newest first
+func (r *SQLRepository) GetRatingHistory(username string, limit int) ([]RatingHistoryEntry, error) {
+	rows, err := r.query(`
+	SELECT game_id, rating_before, rating_after, created_at FROM rating_history
+	WHERE username = $1
+	ORDER BY created_at DESC
+	LIMIT $2
+	`, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []RatingHistoryEntry
+	for rows.Next() {
+		var e RatingHistoryEntry
+		if err := rows.Scan(&e.GameID, &e.RatingBefore, &e.RatingAfter, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// lockRating reads a player's rating row for update, creating it if needed
+func lockRating(tx *dialectTx, username string) (rating.Rating, error) {
+	def := rating.New()
+	_, err := tx.Exec(`
+	INSERT INTO player_ratings (username, rating, rd, volatility, updated_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (username) DO NOTHING
+	`, username, def.Value, def.RD, def.Volatility, time.Now())
+	if err != nil {
+		return def, err
+	}
+
+	var cur rating.Rating
+	err = tx.QueryRow(`
+	SELECT rating, rd, volatility FROM player_ratings WHERE username = $1 FOR UPDATE
+	`, username).Scan(&cur.Value, &cur.RD, &cur.Volatility)
+	return cur, err
+}
+
+func saveRating(tx *dialectTx, username, gameID string, before, after rating.Rating, now time.Time) error {
+	_, err := tx.Exec(`
+	UPDATE player_ratings SET rating = $2, rd = $3, volatility = $4, games = games + 1, updated_at = $5
+	WHERE username = $1
+	`, username, after.Value, after.RD, after.Volatility, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO rating_history (username, game_id, rating_before, rating_after, rd_after, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`, username, gameID, before.Value, after.Value, after.RD, now)
+	return err
+}
+
+// gameRatingChanges reads back the changes a game already made, keyed by
+// username, or nil if it wasn't rated
+func gameRatingChanges(tx *dialectTx, gameID string) (map[string]game.RatingChange, error) {
+	rows, err := tx.Query(`
+	SELECT username, rating_before, rating_after FROM rating_history WHERE game_id = $1
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes map[string]game.RatingChange
+	for rows.Next() {
+		var username string
+		var before, after float64
+		if err := rows.Scan(&username, &before, &after); err != nil {
+			return nil, err
+		}
+		if changes == nil {
+			changes = make(map[string]game.RatingChange)
+		}
+		changes[username] = game.RatingChange{Before: before, After: after, Delta: after - before}
+	}
+	return changes, rows.Err()
+}
+
+// rateGame applies one result to both players. scoreA is from p1's point of view.
+func rateGame(tx *dialectTx, gameID, p1, p2 string, scoreA float64) (map[string]game.RatingChange, error) {
+	// Lock in a fixed order so two concurrent games can't deadlock
+	first, second := p1, p2
+	if second < first {
+		first, second = second, first
+	}
+	locked := map[string]rating.Rating{}
+	for _, u := range []string{first, second} {
+		cur, err := lockRating(tx, u)
+		if err != nil {
+			return nil, err
+		}
+		locked[u] = cur
+	}
+
+	before1, before2 := locked[p1], locked[p2]
+	after1, after2 := rating.Default.Rate(before1, before2, scoreA)
+
+	now := time.Now()
+	if err := saveRating(tx, p1, gameID, before1, after1, now); err != nil {
+		return nil, err
+	}
+	if err := saveRating(tx, p2, gameID, before2, after2, now); err != nil {
+		return nil, err
+	}
+
+	return map[string]game.RatingChange{
+		p1: {Before: before1.Value, After: after1.Value, Delta: after1.Value - before1.Value},
+		p2: {Before: before2.Value, After: after2.Value, Delta: after2.Value - before2.Value},
+	}, nil
+}
@@ -1,127 +1,124 @@
 package db
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"log"
-	"os"
+	"strings"
 	"time"
 
+	"fourinrow/analytics"
+	"fourinrow/config"
 	"fourinrow/game"
-
-	_ "github.com/lib/pq"
 )
 
-type Repository struct {
-	db *sql.DB
+// Repository is everything the server stores outside a running game. It is
+// implemented by SQLRepository (Postgres or SQLite) and MemoryRepository.
+type Repository interface {
+	game.Snapshotter
+	analytics.Outbox
+
+	// SaveGame stores the finished game and, for PvP games, updates both
+	// players' ratings atomically with it. Its game_finished analytics event
+	// goes into the outbox in the same transaction. It returns the rating
+	// changes keyed by username (nil for unrated games).
+	SaveGame(g *game.Game) (map[string]game.RatingChange, error)
+	GetGame(id string) (*StoredGame, error)
+	GetMoves(gameID string) ([]StoredMove, error)
+	// ListGamesForPlayer returns the player's games, newest first
+	ListGamesForPlayer(username string, limit, offset int) ([]StoredGame, error)
+	GetPlayerStats(username string) (*PlayerStats, error)
+	// GetHeadToHead is player's record against opponent, with a page of
+	// their games (newest first)
+	GetHeadToHead(player, opponent string, limit, offset int) (*HeadToHead, error)
+	GetLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, error)
+	// GetLeaderboardPosition is username's entry on the q leaderboard, ignoring
+	// paging, or nil if they don't qualify
+	GetLeaderboardPosition(q LeaderboardQuery, username string) (*LeaderboardEntry, error)
+	// RebuildPlayerStats recomputes the leaderboard's player_stats from the
+	// saved games, which SaveGame otherwise keeps up to date incrementally.
+	// It returns how many games it counted.
+	RebuildPlayerStats() (int, error)
+
+	GetRating(username string) (float64, error)
+	GetRatingHistory(username string, limit int) ([]RatingHistoryEntry, error)
+
+	SaveGuest(id, username string) error
+	GetGuest(id string) (*Guest, error)
+	CreateAccount(username, passwordHash, guestID string) (*Account, error)
+
+	// SaveChatMessage logs chat sent after SaveGame, e.g. a post-game "gg"
+	SaveChatMessage(gameID string, m game.ChatMessage) error
+	SetBlocked(username, target string, on bool) error
+	IsBlocked(username, target string) (bool, error)
+
+	Close() error
 }
 
-type LeaderboardEntry struct {
-	Username  string `json:"username"`
-	TotalWins int    `json:"total_wins"`
+var ErrGameNotFound = errors.New("game not found")
+
+// StoredGame is a finished game as saved by SaveGame. Player1 had color 1.
+// Its moves are stored separately; see GetMoves.
+type StoredGame struct {
+	ID         string    `json:"id"`
+	Player1    string    `json:"player1"`
+	Player2    string    `json:"player2"`
+	Winner     string    `json:"winner"`              // a username, or "draw"
+	EndReason  string    `json:"endReason,omitempty"` // a game.End* constant
+	Variant    string    `json:"variant,omitempty"`
+	MoveCount  int       `json:"moveCount"`
+	BotGame    bool      `json:"botGame"`
+	DurationMs int64     `json:"durationMs"`
+	SeriesID   string    `json:"seriesId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	FinishedAt time.Time `json:"finishedAt"`
 }
 
-var Repo *Repository
+var Repo Repository
 
-func InitDB() {
-	url := os.Getenv("DATABASE_URL")
-	if url == "" {
-		log.Println("DATABASE_URL not set, DB disabled")
-		return
+// InitDB opens the repository named by DATABASE_URL: postgres:// for
+// production, sqlite:PATH for a local file, and memory (also the default
+// when unset) for a throwaway in-process store
+func InitDB(cfg config.Config) {
+	if cfg.DatabaseURL == "" {
+		log.Println("DATABASE_URL not set, keeping results in memory (lost on restart)")
 	}
-
-	// Connect to the DB
-	db, err := sql.Open("postgres", url)
+	repo, err := Open(cfg.DatabaseURL, cfg.ChatLogs)
 	if err != nil {
-		log.Printf("[DB ERROR] Configuration error: %v", err)
+		log.Printf("[DB ERROR] %v", err)
 		return
 	}
+	Repo = repo
+}
 
-	// Test the connection
-	if err := db.Ping(); err != nil {
-		log.Printf("[DB ERROR] Connection failed: %v", err)
-		return
+// Open connects to url and brings its schema up to date
+func Open(url string, persistChat bool) (Repository, error) {
+	if url == "" || url == "memory" || strings.HasPrefix(url, "memory:") {
+		return NewMemoryRepository(), nil
 	}
 
-	log.Println("✅ Successfully connected to PostgreSQL!")
-
-	// Create the table if it doesn't exist
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS games (
-		game_id TEXT PRIMARY KEY,
-		player1 TEXT,
-		player2 TEXT,
-		winner TEXT,
-		created_at TIMESTAMP,
-		finished_at TIMESTAMP
-	)`)
-	
+	db, dialect, err := Connect(url)
 	if err != nil {
-		log.Printf("[DB ERROR] Failed to create table: %v", err)
-		return
-	}
-
-	Repo = &Repository{db: db}
-}
-
-func (r *Repository) SaveGame(g *game.Game) {
-	if r == nil { return }
-
-	var p1, p2 string
-	i := 0
-	for _, p := range g.Players {
-		if i == 0 { p1 = p.Username } else { p2 = p.Username }
-		i++
+		return nil, err
 	}
+	log.Printf("✅ Successfully connected to %s!", dialect.Name)
 
-	// --- FIX START: Correctly find the Winner's Username ---
-	winner := g.Winner // Default to UUID if not found
-	
-	// Iterate through all players to find the one matching the Winner ID
-	for _, p := range g.Players {
-		if p.ID == g.Winner {
-			winner = p.Username
-			break
-		}
+	// Bring the schema up to date (cmd/migrate does the same, and can go down)
+	migrator, err := NewMigrator(db, dialect)
+	if err == nil {
+		_, err = migrator.Up(context.Background())
 	}
-	// --- FIX END ---
-
-	// Don't save if there is no winner
-	if winner == "" { return }
-
-	now := time.Now()
-	_, err := r.db.Exec(`
-	INSERT INTO games (game_id, player1, player2, winner, created_at, finished_at)
-	VALUES ($1, $2, $3, $4, $5, $6)
-	ON CONFLICT (game_id) DO UPDATE SET winner=$4, finished_at=$6
-	`, g.ID, p1, p2, winner, now, now)
-
 	if err != nil {
-		log.Printf("[DB ERROR] Failed to save game: %v", err)
+		db.Close()
+		return nil, err
 	}
+	return NewSQLRepository(db, dialect, persistChat), nil
 }
 
-func (r *Repository) GetLeaderboard() ([]LeaderboardEntry, error) {
-	if r == nil { return nil, nil }
-
-	rows, err := r.db.Query(`
-	SELECT winner, COUNT(*) as wins FROM games
-	WHERE winner != 'draw' AND winner != 'opponent'
-	GROUP BY winner
-	ORDER BY wins DESC
-	LIMIT 10
-	`)
-	if err != nil {
-		return nil, err
+// Close releases the repository. Safe to call when the DB is disabled.
+func Close() error {
+	if Repo == nil {
+		return nil
 	}
-	defer rows.Close()
-
-	var res []LeaderboardEntry
-	for rows.Next() {
-		var e LeaderboardEntry
-		if err := rows.Scan(&e.Username, &e.TotalWins); err != nil {
-			continue
-		}
-		res = append(res, e)
-	}
-	return res, nil
-}
\ No newline at end of file
+	return Repo.Close()
+}
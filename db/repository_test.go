@@ -0,0 +1,42 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fourinrow/db"
+	"fourinrow/db/dbtest"
+)
+
+// runConformance opens url with migrations applied and runs every dbtest case
+// against it
+func runConformance(t *testing.T, url string) {
+	repo, err := db.Open(url, true)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	for _, c := range dbtest.Cases {
+		t.Run(c.Name, func(t *testing.T) { c.Run(t, repo) })
+	}
+}
+
+func TestMemoryRepository(t *testing.T) {
+	runConformance(t, "memory:")
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	runConformance(t, "sqlite:"+filepath.Join(t.TempDir(), "test.db"))
+}
+
+// TestPostgresRepository needs a scratch database, e.g.
+// TEST_DATABASE_URL=postgres://localhost/fourinrow_test?sslmode=disable
+func TestPostgresRepository(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	runConformance(t, url)
+}
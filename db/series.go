@@ -0,0 +1,32 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"fourinrow/game"
+)
+
+// saveSeries upserts the running score and links every game in the series
+func saveSeries(tx *dialectTx, s *game.Series, p1, p2 string, now time.Time) error {
+	// Store the pair in a fixed order so colors swapping doesn't swap columns
+	players := []string{p1, p2}
+	sort.Strings(players)
+	a, b := players[0], players[1]
+
+	_, err := tx.Exec(`
+	INSERT INTO series (series_id, player_a, player_b, wins_a, wins_b, draws, games, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (series_id) DO UPDATE SET wins_a=$4, wins_b=$5, draws=$6, games=$7, updated_at=$8
+	`, s.ID, a, b, s.Score[a], s.Score[b], s.Score["draw"], len(s.GameIDs), now)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range s.GameIDs {
+		if _, err := tx.Exec(`UPDATE games SET series_id = $1 WHERE game_id = $2`, s.ID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+
+	"fourinrow/game"
+)
+
+// The Repository doubles as a game.Snapshotter, keeping in-progress games in
+// the active_games table so they survive a restart
+
+func (r *SQLRepository) SaveSnapshot(g *game.Game) error {
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		return err
+	}
+	_, err = r.exec(`
+	INSERT INTO active_games (game_id, state, updated_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (game_id) DO UPDATE SET state=$2, updated_at=$3
+	`, g.ID, data, time.Now())
+	return err
+}
+
+func (r *SQLRepository) DeleteSnapshot(id string) error {
+	_, err := r.exec(`DELETE FROM active_games WHERE game_id = $1`, id)
+	return err
+}
+
+func (r *SQLRepository) LoadSnapshots() ([]*game.Game, error) {
+	rows, err := r.query(`SELECT state FROM active_games`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []*game.Game
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if g, err := game.DecodeGame(data); err == nil {
+			games = append(games, g)
+		}
+	}
+	return games, rows.Err()
+}
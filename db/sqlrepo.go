@@ -0,0 +1,240 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/rating"
+)
+
+// SQLRepository stores everything in Postgres or SQLite. Queries are written
+// for Postgres; the dialect rewrites them for SQLite.
+type SQLRepository struct {
+	db          *sql.DB
+	dialect     *Dialect
+	persistChat bool // store the chat log with each game
+}
+
+func NewSQLRepository(db *sql.DB, d *Dialect, persistChat bool) *SQLRepository {
+	return &SQLRepository{db: db, dialect: d, persistChat: persistChat}
+}
+
+func (r *SQLRepository) Close() error { return r.db.Close() }
+
+func (r *SQLRepository) exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.db.Exec(r.dialect.rebind(query), args...)
+}
+
+func (r *SQLRepository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.Query(r.dialect.rebind(query), args...)
+}
+
+func (r *SQLRepository) queryRow(query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRow(r.dialect.rebind(query), args...)
+}
+
+func (r *SQLRepository) begin() (*dialectTx, error) {
+	t, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: t, dialect: r.dialect}, nil
+}
+
+// dialectTx is a transaction that rewrites queries for the repository's dialect
+type dialectTx struct {
+	*sql.Tx
+	dialect *Dialect
+}
+
+func (t *dialectTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *dialectTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *dialectTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+// gameRow is the games row SaveGame writes for g, or ok=false if there is
+// nothing to save yet. Player 1 is whoever has color 1, so the row is stable
+// across saves.
+func gameRow(g *game.Game, now time.Time) (row StoredGame, p1, p2 *game.Player, ok bool) {
+	for _, p := range g.Players {
+		if p.Color == 1 {
+			p1 = p
+		} else {
+			p2 = p
+		}
+	}
+	if p1 == nil || p2 == nil {
+		return row, nil, nil, false
+	}
+
+	// The winner is stored by username; Winner holds the player ID or "draw"
+	winner := g.Winner
+	for _, p := range g.Players {
+		if p.ID == g.Winner {
+			winner = p.Username
+			break
+		}
+	}
+	// Don't save if there is no winner
+	if winner == "" {
+		return row, nil, nil, false
+	}
+
+	created, finished := gameTimes(g, now)
+	row = StoredGame{
+		ID: g.ID, Player1: p1.Username, Player2: p2.Username, Winner: winner,
+		EndReason: g.EndReason, Variant: g.Variant, MoveCount: len(g.Moves),
+		BotGame:    p1.IsBot || p2.IsBot,
+		DurationMs: finished.Sub(created).Milliseconds(),
+		CreatedAt:  created, FinishedAt: finished,
+	}
+	return row, p1, p2, true
+}
+
+// scoreFor is the result from p1's point of view
+func scoreFor(winner, p1, p2 string) float64 {
+	switch winner {
+	case p1:
+		return rating.Win
+	case p2:
+		return rating.Loss
+	}
+	return rating.Draw
+}
+
+func (r *SQLRepository) SaveGame(g *game.Game) (map[string]game.RatingChange, error) {
+	now := time.Now()
+	row, p1, p2, ok := gameRow(g, now)
+	if !ok {
+		return nil, nil
+	}
+
+	tx, err := r.begin()
+	if err != nil {
+		return nil, fmt.Errorf("save game: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A resave replaces the game's earlier result in player_stats
+	var prev *StoredGame
+	if sg, err := scanGame(tx.QueryRow(`SELECT `+gameColumns+` FROM games WHERE game_id = $1 FOR UPDATE`, g.ID)); err == nil {
+		prev = &sg
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("save game: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO games (game_id, player1, player2, winner, end_reason, variant, move_count, duration_ms, bot_game, created_at, finished_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	ON CONFLICT (game_id) DO UPDATE SET winner=$4, end_reason=$5, move_count=$7, duration_ms=$8, finished_at=$11
+	`, row.ID, row.Player1, row.Player2, row.Winner, row.EndReason, row.Variant, row.MoveCount, row.DurationMs, row.BotGame, row.CreatedAt, row.FinishedAt)
+	if err != nil {
+		return nil, fmt.Errorf("save game: %w", err)
+	}
+
+	cur := row
+	if prev != nil {
+		// ON CONFLICT keeps the players, variant, start and series
+		cur = *prev
+		cur.Winner, cur.EndReason, cur.MoveCount, cur.DurationMs, cur.FinishedAt =
+			row.Winner, row.EndReason, row.MoveCount, row.DurationMs, row.FinishedAt
+	}
+	if err := updateStats(tx, prev, &cur); err != nil {
+		return nil, fmt.Errorf("update player stats: %w", err)
+	}
+
+	// The game_finished event commits or rolls back with the game; the
+	// outbox relay publishes it
+	if err := addToOutbox(tx, gameFinishedEvent(g, now), now); err != nil {
+		return nil, fmt.Errorf("queue game_finished: %w", err)
+	}
+
+	// The moves go in with the game row, so a saved game is always replayable
+	if err := saveMoves(tx, g.ID, gameMoves(g)); err != nil {
+		return nil, fmt.Errorf("save moves: %w", err)
+	}
+
+	if g.Series != nil {
+		if err := saveSeries(tx, g.Series, p1.Username, p2.Username, now); err != nil {
+			return nil, fmt.Errorf("save series: %w", err)
+		}
+	}
+
+	if r.persistChat && len(g.Chat) > 0 {
+		if err := saveChat(tx, g); err != nil {
+			return nil, fmt.Errorf("save chat log: %w", err)
+		}
+	}
+
+	// Bot games are never rated, otherwise farming the bot pays off. Only
+	// the first save rates a game; a resave reports what that one did.
+	var changes map[string]game.RatingChange
+	switch {
+	case p1.IsBot || p2.IsBot:
+	case prev == nil:
+		changes, err = rateGame(tx, g.ID, p1.Username, p2.Username, scoreFor(row.Winner, p1.Username, p2.Username))
+	default:
+		changes, err = gameRatingChanges(tx, g.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update ratings: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("save game: %w", err)
+	}
+	return changes, nil
+}
+
+const gameColumns = `game_id, player1, player2, winner, COALESCE(end_reason, ''), COALESCE(variant, ''),
+	move_count, duration_ms, bot_game, COALESCE(series_id, ''), created_at, finished_at`
+
+func scanGame(row interface{ Scan(...interface{}) error }) (StoredGame, error) {
+	var sg StoredGame
+	err := row.Scan(&sg.ID, &sg.Player1, &sg.Player2, &sg.Winner, &sg.EndReason, &sg.Variant,
+		&sg.MoveCount, &sg.DurationMs, &sg.BotGame, &sg.SeriesID, &sg.CreatedAt, &sg.FinishedAt)
+	return sg, err
+}
+
+func (r *SQLRepository) GetGame(id string) (*StoredGame, error) {
+	sg, err := scanGame(r.queryRow(`SELECT `+gameColumns+` FROM games WHERE game_id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sg, nil
+}
+
+func (r *SQLRepository) ListGamesForPlayer(username string, limit, offset int) ([]StoredGame, error) {
+	rows, err := r.query(`
+	SELECT `+gameColumns+` FROM games
+	WHERE player1 = $1 OR player2 = $1
+	ORDER BY finished_at DESC, game_id
+	LIMIT $2 OFFSET $3
+	`, username, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []StoredGame
+	for rows.Next() {
+		sg, err := scanGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sg)
+	}
+	return res, rows.Err()
+}
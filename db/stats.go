@@ -0,0 +1,145 @@
+package db
+
+import "database/sql"
+
+// Results is a win/loss/draw tally
+type Results struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	Losses  int     `json:"losses"`
+	Draws   int     `json:"draws"`
+	WinRate float64 `json:"winRate"` // wins / games, 0 with no games
+}
+
+func (r *Results) add(o Results) {
+	r.Games += o.Games
+	r.Wins += o.Wins
+	r.Losses += o.Losses
+	r.Draws += o.Draws
+}
+
+func (r *Results) finish() {
+	r.Losses = r.Games - r.Wins - r.Draws
+	if r.Games > 0 {
+		r.WinRate = float64(r.Wins) / float64(r.Games)
+	}
+}
+
+// PlayerStats summarizes every saved game a player took part in
+type PlayerStats struct {
+	Username string `json:"username"`
+	Results
+	AsPlayer1 Results `json:"asPlayer1"` // moved first (color 1)
+	AsPlayer2 Results `json:"asPlayer2"`
+	PvP       Results `json:"pvp"`
+	VsBot     Results `json:"vsBot"`
+
+	LongestWinStreak int     `json:"longestWinStreak"`
+	AvgMoves         float64 `json:"avgMoves"`
+	AvgDurationMs    int64   `json:"avgDurationMs"`
+	// Column the player most often opens with (ply 1), nil if they never have
+	FavoriteOpening *int `json:"favoriteOpening"`
+}
+
+// statsGroup is one (seat, opponent type) bucket of a player's games
+type statsGroup struct {
+	player1, botGame bool
+	results          Results
+	moves, duration  int64
+}
+
+// buildStats folds the buckets into the splits and averages
+func buildStats(username string, groups []statsGroup) *PlayerStats {
+	s := &PlayerStats{Username: username}
+	var moves, duration int64
+	for _, g := range groups {
+		s.Results.add(g.results)
+		if g.player1 {
+			s.AsPlayer1.add(g.results)
+		} else {
+			s.AsPlayer2.add(g.results)
+		}
+		if g.botGame {
+			s.VsBot.add(g.results)
+		} else {
+			s.PvP.add(g.results)
+		}
+		moves += g.moves
+		duration += g.duration
+	}
+	for _, r := range []*Results{&s.Results, &s.AsPlayer1, &s.AsPlayer2, &s.PvP, &s.VsBot} {
+		r.finish()
+	}
+	if s.Games > 0 {
+		s.AvgMoves = float64(moves) / float64(s.Games)
+		s.AvgDurationMs = duration / int64(s.Games)
+	}
+	return s
+}
+
+func (r *SQLRepository) GetPlayerStats(username string) (*PlayerStats, error) {
+	// One pass over the player's games (games_player1_idx/games_player2_idx)
+	rows, err := r.query(`
+	SELECT player1 = $1, bot_game, COUNT(*),
+		SUM(CASE WHEN winner = $1 THEN 1 ELSE 0 END),
+		SUM(CASE WHEN winner = 'draw' THEN 1 ELSE 0 END),
+		SUM(move_count), SUM(duration_ms)
+	FROM games
+	WHERE player1 = $1 OR player2 = $1
+	GROUP BY 1, 2
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []statsGroup
+	for rows.Next() {
+		var g statsGroup
+		if err := rows.Scan(&g.player1, &g.botGame, &g.results.Games, &g.results.Wins, &g.results.Draws, &g.moves, &g.duration); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s := buildStats(username, groups)
+	if s.Games == 0 {
+		return s, nil
+	}
+
+	// Number the games in order, and separately within wins and non-wins; the
+	// difference is constant along each run of consecutive results
+	err = r.queryRow(`
+	SELECT COALESCE(MAX(n), 0) FROM (
+		SELECT COUNT(*) AS n FROM (
+			SELECT winner = $1 AS won,
+				ROW_NUMBER() OVER (ORDER BY finished_at, game_id)
+				- ROW_NUMBER() OVER (PARTITION BY winner = $1 ORDER BY finished_at, game_id) AS run
+			FROM games
+			WHERE player1 = $1 OR player2 = $1
+		) results
+		WHERE won
+		GROUP BY run
+	) runs
+	`, username).Scan(&s.LongestWinStreak)
+	if err != nil {
+		return nil, err
+	}
+
+	var column int
+	err = r.queryRow(`
+	SELECT column_index FROM moves
+	WHERE player = $1 AND ply = 1
+	GROUP BY column_index
+	ORDER BY COUNT(*) DESC, column_index
+	LIMIT 1
+	`, username).Scan(&column)
+	if err == nil {
+		s.FavoriteOpening = &column
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"math/rand"
+
+	"fourinrow/game"
+)
+
+// Level controls how hard the bot plays
+type Level int
+
+const (
+	LevelEasy   Level = iota // mostly random, sometimes blocks
+	LevelNormal              // the classic win/block/center heuristic
+	LevelHard                // shallow lookahead
+	LevelExpert              // deep lookahead
+)
+
+// LevelForRating picks a bot strength that gives a player of this rating a fair game
+func LevelForRating(rating float64) Level {
+	switch {
+	case rating < 1300:
+		return LevelEasy
+	case rating < 1600:
+		return LevelNormal
+	case rating < 1850:
+		return LevelHard
+	default:
+		return LevelExpert
+	}
+}
+
+// GetMove returns the bot's column for the given strength
+func GetMove(g *game.Game, botColor int, level Level) (int, error) {
+	switch level {
+	case LevelEasy:
+		return easyMove(g, botColor)
+	case LevelHard:
+		return searchMove(g.Board, botColor, 4)
+	case LevelExpert:
+		return searchMove(g.Board, botColor, 7)
+	default:
+		return GetBestMove(g, botColor)
+	}
+}
+
+// easyMove plays the heuristic half of the time and a random column otherwise
+func easyMove(g *game.Game, botColor int) (int, error) {
+	valid := validMoves(g.Board)
+	if len(valid) == 0 {
+		return GetBestMove(g, botColor)
+	}
+	if rand.Intn(2) == 0 {
+		return GetBestMove(g, botColor)
+	}
+	return valid[rand.Intn(len(valid))], nil
+}
+
+// searchMove runs a depth-limited negamax with alpha-beta pruning
+func searchMove(board [6][7]int, botColor, depth int) (int, error) {
+	valid := validMoves(board)
+	if len(valid) == 0 {
+		return GetBestMove(&game.Game{Board: board}, botColor)
+	}
+
+	// Always take an immediate win before searching
+	for _, c := range valid {
+		if canWin(board, c, botColor) {
+			return c, nil
+		}
+	}
+
+	best, bestScore := valid[0], -1<<30
+	for _, c := range searchOrder {
+		r := dropRow(board, c)
+		if r < 0 {
+			continue
+		}
+		board[r][c] = botColor
+		score := -negamax(board, depth-1, -1<<30, 1<<30, 3-botColor)
+		board[r][c] = 0
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best, nil
+}
+
+var searchOrder = []int{3, 2, 4, 1, 5, 0, 6}
+
+func negamax(board [6][7]int, depth, alpha, beta, color int) int {
+	valid := validMoves(board)
+	if len(valid) == 0 {
+		return 0
+	}
+	for _, c := range valid {
+		if canWin(board, c, color) {
+			return 100000 + depth
+		}
+	}
+	if depth == 0 {
+		return evaluate(board, color)
+	}
+
+	for _, c := range searchOrder {
+		r := dropRow(board, c)
+		if r < 0 {
+			continue
+		}
+		board[r][c] = color
+		score := -negamax(board, depth-1, -beta, -alpha, 3-color)
+		board[r][c] = 0
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return alpha
+}
+
+func dropRow(board [6][7]int, col int) int {
+	for r := 5; r >= 0; r-- {
+		if board[r][col] == 0 {
+			return r
+		}
+	}
+	return -1
+}
+
+// evaluate scores every 4-cell window from color's point of view
+func evaluate(b [6][7]int, color int) int {
+	score := 0
+	for r := 0; r < 6; r++ {
+		if b[r][3] == color {
+			score += 3
+		}
+	}
+	d := [][2]int{{0, 1}, {1, 0}, {1, 1}, {-1, 1}}
+	for r := 0; r < 6; r++ {
+		for c := 0; c < 7; c++ {
+			for _, x := range d {
+				er, ec := r+3*x[0], c+3*x[1]
+				if er < 0 || er >= 6 || ec >= 7 {
+					continue
+				}
+				mine, theirs := 0, 0
+				for i := 0; i < 4; i++ {
+					switch b[r+i*x[0]][c+i*x[1]] {
+					case color:
+						mine++
+					case 0:
+					default:
+						theirs++
+					}
+				}
+				score += windowScore(mine, theirs)
+			}
+		}
+	}
+	return score
+}
+
+func windowScore(mine, theirs int) int {
+	if mine > 0 && theirs > 0 {
+		return 0
+	}
+	switch {
+	case mine == 3:
+		return 50
+	case mine == 2:
+		return 10
+	case theirs == 3:
+		return -60
+	case theirs == 2:
+		return -10
+	}
+	return 0
+}
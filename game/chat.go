@@ -0,0 +1,11 @@
+package game
+
+import "time"
+
+// ChatMessage is one chat line or emote sent during a game
+type ChatMessage struct {
+	From   string    `json:"from"`
+	Kind   string    `json:"kind"` // "chat" or "emote"
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sentAt"`
+}
@@ -0,0 +1,61 @@
+package game
+
+import "time"
+
+// Clock is a per-player countdown (Fischer increment), keyed by player ID.
+// Only the player whose turn it is has time running.
+type Clock struct {
+	Initial       time.Duration            `json:"-"`
+	Increment     time.Duration            `json:"-"`
+	Remaining     map[string]time.Duration `json:"-"`
+	TurnStartedAt time.Time                `json:"-"`
+
+	// Milliseconds left per player as of the last Punch, for the client
+	RemainingMs map[string]int64 `json:"remainingMs"`
+	IncrementMs int64            `json:"incrementMs"`
+}
+
+func NewClock(initial, increment time.Duration, playerIDs ...string) *Clock {
+	c := &Clock{
+		Initial:     initial,
+		Increment:   increment,
+		Remaining:   make(map[string]time.Duration),
+		RemainingMs: make(map[string]int64),
+		IncrementMs: increment.Milliseconds(),
+	}
+	for _, id := range playerIDs {
+		c.Remaining[id] = initial
+		c.RemainingMs[id] = initial.Milliseconds()
+	}
+	return c
+}
+
+// Start begins the first player's countdown
+func (c *Clock) Start(now time.Time) {
+	c.TurnStartedAt = now
+}
+
+// Left is how much time playerID has, counting the running turn if it's theirs
+func (c *Clock) Left(playerID, currentTurn string, now time.Time) time.Duration {
+	left := c.Remaining[playerID]
+	if playerID == currentTurn {
+		left -= now.Sub(c.TurnStartedAt)
+	}
+	return left
+}
+
+// Punch stops the mover's clock after a move, adds the increment and starts
+// the opponent's clock. It reports false if the mover had already run out.
+func (c *Clock) Punch(moverID string, now time.Time) bool {
+	left := c.Remaining[moverID] - now.Sub(c.TurnStartedAt)
+	if left <= 0 {
+		c.Remaining[moverID] = 0
+		c.RemainingMs[moverID] = 0
+		return false
+	}
+	left += c.Increment
+	c.Remaining[moverID] = left
+	c.RemainingMs[moverID] = left.Milliseconds()
+	c.TurnStartedAt = now
+	return true
+}
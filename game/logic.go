@@ -2,6 +2,7 @@ package game
 
 import (
 	"errors"
+	"time"
 )
 
 func ApplyMove(g *Game, playerID string, col int) error {
@@ -51,11 +52,13 @@ func ApplyMove(g *Game, playerID string, col int) error {
 
 	// 3. Update Board
 	g.Board[rowIndex][col] = playerColor
+	g.Moves = append(g.Moves, Move{Ply: len(g.Moves) + 1, PlayerID: playerID, Column: col, Row: rowIndex, At: time.Now()})
 
 	// 4. Check Win
 	if CheckWin(g.Board, playerColor) {
 		g.Status = "finished"
 		g.Winner = playerID
+		g.EndReason = EndConnectFour
 		return nil
 	}
 
@@ -70,6 +73,7 @@ func ApplyMove(g *Game, playerID string, col int) error {
 	if isFull {
 		g.Status = "finished"
 		g.Winner = "draw"
+		g.EndReason = EndBoardFull
 		return nil
 	}
 
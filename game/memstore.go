@@ -0,0 +1,154 @@
+package game
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps games in a map. It is the default GameStore and also
+// the local cache behind other backends.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	games map[string]*Game
+	// version last saved per game, for optimistic updates
+	versions map[string]int64
+	// username -> that player's most recent game, so lookups don't scan
+	byPlayer map[string]*Game
+	limit    int
+}
+
+func NewMemoryStore(limit int) *MemoryStore {
+	return &MemoryStore{
+		games:    make(map[string]*Game),
+		versions: make(map[string]int64),
+		byPlayer: make(map[string]*Game),
+		limit:    limit,
+	}
+}
+
+// Add stores a new game. At capacity it first drops the oldest finished
+// games; if everything left is live it refuses with ErrStoreFull.
+func (s *MemoryStore) Add(g *Game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.games) >= s.limit {
+		s.evictOldestFinished(len(s.games) - s.limit + 1)
+	}
+	if len(s.games) >= s.limit {
+		counters.rejected.Add(1)
+		return ErrStoreFull
+	}
+
+	g.Version = 1
+	s.put(g)
+	counters.added.Add(1)
+	return nil
+}
+
+// Adopt takes ownership of a game loaded from elsewhere, keeping its version
+func (s *MemoryStore) Adopt(g *Game) error {
+	s.Put(g)
+	return nil
+}
+
+// Put stores g as-is, keeping its version. Backends use it to cache games
+// they loaded from elsewhere.
+func (s *MemoryStore) Put(g *Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(g)
+}
+
+func (s *MemoryStore) put(g *Game) {
+	s.games[g.ID] = g
+	s.versions[g.ID] = g.Version
+	for name := range g.Players {
+		if cur := s.byPlayer[name]; cur == nil || !cur.CreatedAt.After(g.CreatedAt) {
+			s.byPlayer[name] = g
+		}
+	}
+}
+
+func (s *MemoryStore) Get(id string) (*Game, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if g := s.games[id]; g != nil {
+		return g, nil
+	}
+	return nil, ErrGameNotFound
+}
+
+func (s *MemoryStore) Update(g *Game) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.versions[g.ID]
+	if !ok {
+		return ErrGameNotFound
+	}
+	if v != g.Version {
+		return ErrVersionConflict
+	}
+	g.Version++
+	s.put(g)
+	return nil
+}
+
+func (s *MemoryStore) FindByPlayer(username string) (*Game, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if g := s.byPlayer[username]; g != nil {
+		return g, nil
+	}
+	return nil, ErrGameNotFound
+}
+
+func (s *MemoryStore) List() ([]*Game, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*Game, 0, len(s.games))
+	for _, g := range s.games {
+		res = append(res, g)
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+	return nil
+}
+
+func (s *MemoryStore) remove(id string) {
+	g := s.games[id]
+	if g == nil {
+		return
+	}
+	delete(s.games, id)
+	delete(s.versions, id)
+	for name := range g.Players {
+		if s.byPlayer[name] == g {
+			delete(s.byPlayer, name)
+		}
+	}
+}
+
+// evictOldestFinished drops up to n finished games, oldest finish first
+func (s *MemoryStore) evictOldestFinished(n int) {
+	var finished []*Game
+	for _, g := range s.games {
+		if g.Status == "finished" {
+			finished = append(finished, g)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(finished[j].FinishedAt) })
+	for i := 0; i < n && i < len(finished); i++ {
+		s.remove(finished[i].ID)
+		counters.evicted.Add(1)
+	}
+}
@@ -0,0 +1,14 @@
+package game_test
+
+import (
+	"testing"
+
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	for _, c := range storetest.Cases {
+		t.Run(c.Name, func(t *testing.T) { c.Run(t, game.NewMemoryStore(game.MaxGames)) })
+	}
+}
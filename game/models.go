@@ -1,29 +1,106 @@
 package game
 
 import (
-	"github.com/gorilla/websocket"
+	"sync"
 	"time"
 )
 
+// Conn is how the server talks to a player. A *websocket.Conn satisfies it;
+// in a cluster it can also be a relay to the node holding the socket.
+type Conn interface {
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
+// BotUsername is who the computer opponent plays as
+const BotUsername = "Bot 🤖"
+
 type Player struct {
 	ID              string          `json:"id"`
 	Username        string          `json:"username"`
 	Color           int             `json:"color"` 
-	Conn            *websocket.Conn `json:"-"`     
+	Conn            Conn            `json:"-"`     
 	IsBot           bool            `json:"isBot"`
 	IsConnected     bool            `json:"isConnected"`
 	DisconnectTimer *time.Timer     `json:"-"` // Needed for 30s timeout
 	GameID          string          `json:"gameId"`
+	Rating          float64         `json:"rating,omitempty"`
 }
 
 type Game struct {
 	ID          string             `json:"id"`
+	Version     int64              `json:"version"` // bumped on every store Update
 	Board       [6][7]int          `json:"board"`
 	Players     map[string]*Player `json:"players"`
 	CurrentTurn string             `json:"currentTurn"` 
 	Status      string             `json:"status"`      
 	Winner      string             `json:"winner,omitempty"`
+	EndReason   string             `json:"endReason,omitempty"` // one of the End* constants once finished
 	CreatedAt   time.Time          `json:"-"`
+	FinishedAt  time.Time          `json:"-"`
+	BotLevel    int                `json:"-"` // bot.Level for PvE games
+	Variant     string             `json:"variant"`
+	Owner       string             `json:"-"` // cluster node hosting the game, empty when single-node
+	Clock       *Clock             `json:"clock,omitempty"`
+	ClockTimer  *time.Timer        `json:"-"` // fires when the player to move runs out of time
+	Series      *Series            `json:"series,omitempty"`
+	Moves       []Move             `json:"moves"`
+	Chat        []ChatMessage      `json:"-"` // relayed live, persisted with the game if enabled
+
+	// Kept in sync by the server's spectator hub
+	SpectatorCount int `json:"spectatorCount"`
+
+	// Set by the first player to offer a rematch once the game is over
+	RematchOfferedBy string `json:"rematchOfferedBy,omitempty"`
+	RematchGameID    string `json:"rematchGameId,omitempty"`
+
+	// Filled in when a rated game finishes, keyed by username
+	RatingChanges map[string]RatingChange `json:"ratingChanges,omitempty"`
+
+	mu    sync.Mutex
+	ended bool // the end of the game has been handled
+}
+
+// Lock serializes everything that changes a live game: moves, clock and
+// forfeit timers, chat, reconnects and the end of the game. Hold it while
+// broadcasting too, so nobody sees a half-applied move.
+func (g *Game) Lock() { g.mu.Lock() }
+
+func (g *Game) Unlock() { g.mu.Unlock() }
+
+// MarkEnded reports whether this is the first call, so the result is
+// recorded once even if a winning move, a flag and a forfeit race to end
+// the game. Callers hold g's lock.
+func (g *Game) MarkEnded() bool {
+	if g.ended {
+		return false
+	}
+	g.ended = true
+	return true
+}
+
+// Why a game finished
+const (
+	EndConnectFour = "connect_four" // four in a row
+	EndBoardFull   = "board_full"   // draw
+	EndTimeout     = "timeout"      // a clock ran out
+	EndDisconnect  = "disconnect"   // forfeited by not reconnecting
+	EndAbandoned   = "abandoned"    // nobody came back, so no result
+)
+
+// Move is one disc dropped, in the order it was played
+type Move struct {
+	Ply      int       `json:"ply"`
+	PlayerID string    `json:"playerId"`
+	Column   int       `json:"column"`
+	Row      int       `json:"row"`
+	At       time.Time `json:"at"`
+}
+
+type RatingChange struct {
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
 }
 
 type WSMessage struct {
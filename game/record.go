@@ -0,0 +1,126 @@
+package game
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// GameRecord is the full serializable state of a game: everything except
+// live connections and timers. Backends and snapshots store this.
+type GameRecord struct {
+	ID               string                  `json:"id"`
+	Version          int64                   `json:"version"`
+	Board            [6][7]int               `json:"board"`
+	Players          []PlayerRecord          `json:"players"`
+	CurrentTurn      string                  `json:"currentTurn"`
+	Status           string                  `json:"status"`
+	Winner           string                  `json:"winner,omitempty"`
+	EndReason        string                  `json:"endReason,omitempty"`
+	CreatedAt        time.Time               `json:"createdAt"`
+	FinishedAt       time.Time               `json:"finishedAt"`
+	BotLevel         int                     `json:"botLevel"`
+	Variant          string                  `json:"variant"`
+	Owner            string                  `json:"owner,omitempty"`
+	Clock            *ClockRecord            `json:"clock,omitempty"`
+	Series           *Series                 `json:"series,omitempty"`
+	Moves            []Move                  `json:"moves"`
+	Chat             []ChatMessage           `json:"chat,omitempty"`
+	RematchOfferedBy string                  `json:"rematchOfferedBy,omitempty"`
+	RematchGameID    string                  `json:"rematchGameId,omitempty"`
+	RatingChanges    map[string]RatingChange `json:"ratingChanges,omitempty"`
+}
+
+type PlayerRecord struct {
+	Key      string  `json:"key,omitempty"` // the player's key in Game.Players
+	ID       string  `json:"id"`
+	Username string  `json:"username"`
+	Color    int     `json:"color"`
+	IsBot    bool    `json:"isBot"`
+	Rating   float64 `json:"rating,omitempty"`
+}
+
+type ClockRecord struct {
+	InitialMs     int64            `json:"initialMs"`
+	IncrementMs   int64            `json:"incrementMs"`
+	RemainingMs   map[string]int64 `json:"remainingMs"`
+	TurnStartedAt time.Time        `json:"turnStartedAt"`
+}
+
+// ToRecord captures g's state. It doesn't keep references into g.
+func ToRecord(g *Game) GameRecord {
+	r := GameRecord{
+		ID: g.ID, Version: g.Version, Board: g.Board, CurrentTurn: g.CurrentTurn,
+		Status: g.Status, Winner: g.Winner, EndReason: g.EndReason, CreatedAt: g.CreatedAt, FinishedAt: g.FinishedAt,
+		BotLevel: g.BotLevel, Variant: g.Variant, Owner: g.Owner, Series: g.Series.Clone(),
+		Moves:            append([]Move(nil), g.Moves...),
+		Chat:             append([]ChatMessage(nil), g.Chat...),
+		RematchOfferedBy: g.RematchOfferedBy, RematchGameID: g.RematchGameID,
+	}
+	if g.RatingChanges != nil {
+		r.RatingChanges = make(map[string]RatingChange, len(g.RatingChanges))
+		for k, v := range g.RatingChanges {
+			r.RatingChanges[k] = v
+		}
+	}
+	for key, p := range g.Players {
+		r.Players = append(r.Players, PlayerRecord{Key: key, ID: p.ID, Username: p.Username, Color: p.Color, IsBot: p.IsBot, Rating: p.Rating})
+	}
+	if c := g.Clock; c != nil {
+		r.Clock = &ClockRecord{
+			InitialMs: c.Initial.Milliseconds(), IncrementMs: c.Increment.Milliseconds(),
+			RemainingMs: make(map[string]int64), TurnStartedAt: c.TurnStartedAt,
+		}
+		for id, left := range c.Remaining {
+			r.Clock.RemainingMs[id] = left.Milliseconds()
+		}
+	}
+	return r
+}
+
+// Game rebuilds a game from the record. Players come back disconnected;
+// whoever owns the game reattaches their sockets.
+func (r GameRecord) Game() *Game {
+	g := &Game{
+		ID: r.ID, Version: r.Version, Board: r.Board, Players: make(map[string]*Player),
+		CurrentTurn: r.CurrentTurn, Status: r.Status, Winner: r.Winner, EndReason: r.EndReason,
+		CreatedAt: r.CreatedAt, FinishedAt: r.FinishedAt, BotLevel: r.BotLevel,
+		Variant: r.Variant, Owner: r.Owner, Series: r.Series, Moves: r.Moves, Chat: r.Chat,
+		RematchOfferedBy: r.RematchOfferedBy, RematchGameID: r.RematchGameID,
+		RatingChanges: r.RatingChanges,
+	}
+	for _, p := range r.Players {
+		// Records written before Key was kept: the bot sits under its ID
+		key := p.Key
+		if key == "" {
+			key = p.Username
+			if p.IsBot {
+				key = p.ID
+			}
+		}
+		g.Players[key] = &Player{
+			ID: p.ID, Username: p.Username, Color: p.Color, IsBot: p.IsBot, Rating: p.Rating,
+			GameID: r.ID, IsConnected: p.IsBot,
+		}
+	}
+	if c := r.Clock; c != nil {
+		g.Clock = NewClock(time.Duration(c.InitialMs)*time.Millisecond, time.Duration(c.IncrementMs)*time.Millisecond)
+		g.Clock.TurnStartedAt = c.TurnStartedAt
+		for id, ms := range c.RemainingMs {
+			g.Clock.Remaining[id] = time.Duration(ms) * time.Millisecond
+			g.Clock.RemainingMs[id] = ms
+		}
+	}
+	return g
+}
+
+func EncodeGame(g *Game) ([]byte, error) {
+	return json.Marshal(ToRecord(g))
+}
+
+func DecodeGame(data []byte) (*Game, error) {
+	var r GameRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return r.Game(), nil
+}
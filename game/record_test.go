@@ -0,0 +1,59 @@
+package game_test
+
+import (
+	"testing"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+func TestRecordKeepsPlayerKeys(t *testing.T) {
+	g := storetest.NewGame("alice", "bob", time.Now())
+	bob := g.Players["bob"]
+	delete(g.Players, "bob")
+	g.Players["cpu"] = &game.Player{ID: "cpu", Username: game.BotUsername, Color: bob.Color, IsBot: true}
+
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		t.Fatalf("EncodeGame: %v", err)
+	}
+	back, err := game.DecodeGame(data)
+	if err != nil {
+		t.Fatalf("DecodeGame: %v", err)
+	}
+	if p := back.Players["cpu"]; p == nil || !p.IsBot || p.Username != game.BotUsername {
+		t.Errorf("restored players = %v, want the bot under \"cpu\"", back.Players)
+	}
+	if back.Players["alice"] == nil {
+		t.Error("alice is missing from the restored players")
+	}
+
+	// Records from before keys were kept
+	r := game.ToRecord(g)
+	for i := range r.Players {
+		r.Players[i].Key = ""
+	}
+	if back := r.Game(); back.Players["cpu"] == nil || back.Players["alice"] == nil {
+		t.Errorf("players from a record without keys = %v", back.Players)
+	}
+}
+
+func TestToRecordCopies(t *testing.T) {
+	g := storetest.NewGame("alice", "bob", time.Now())
+	g.Series = game.NewSeries("s1", g)
+	g.Series.GameIDs = []string{g.ID}
+	g.RatingChanges = map[string]game.RatingChange{"alice": {Before: 1500, After: 1510}}
+
+	r := game.ToRecord(g)
+	g.Series.Score["alice"]++
+	g.Series.GameIDs[0] = "changed"
+	g.RatingChanges["alice"] = game.RatingChange{}
+
+	if r.Series == g.Series || r.Series.Score["alice"] != 0 || r.Series.GameIDs[0] != g.ID {
+		t.Errorf("record series = %+v, changed along with the game", r.Series)
+	}
+	if r.RatingChanges["alice"].After != 1510 {
+		t.Errorf("record rating changes = %v, changed along with the game", r.RatingChanges)
+	}
+}
@@ -0,0 +1,271 @@
+// Package redisstore is a game.GameStore backed by anything that speaks the
+// Redis protocol (Redis, KeyDB, Dragonfly, or miniredis in tests).
+//
+// Games owned by this process are also kept in a local game.MemoryStore, so
+// Get and FindByPlayer hand back the live *Game with its connections. Games
+// only found in Redis (e.g. after a restart) come back disconnected.
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"fourinrow/game"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "fourinrow:"
+	gamesSet  = keyPrefix + "games"
+	// Finished games don't need to outlive the in-memory grace period by much
+	finishedTTL = 2 * game.FinishedGrace
+	opTimeout   = 2 * time.Second
+)
+
+type Store struct {
+	rdb   redis.UniversalClient
+	local *game.MemoryStore
+}
+
+// New wraps an existing client. Pass a client pointed at miniredis to test.
+func New(rdb redis.UniversalClient) *Store {
+	return &Store{rdb: rdb, local: game.NewMemoryStore(game.MaxGames)}
+}
+
+// Dial connects to a redis:// URL and checks the server is reachable
+func Dial(url string) (*Store, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return New(rdb), nil
+}
+
+func (s *Store) Close() error { return s.rdb.Close() }
+
+func gameKey(id string) string         { return keyPrefix + "game:" + id }
+func playerKey(username string) string { return keyPrefix + "player:" + username }
+
+func ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), opTimeout)
+}
+
+func (s *Store) Add(g *game.Game) error {
+	if err := s.local.Add(g); err != nil {
+		return err
+	}
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		s.local.Delete(g.ID)
+		return err
+	}
+
+	c, cancel := ctx()
+	defer cancel()
+	ok, err := s.rdb.SetNX(c, gameKey(g.ID), data, 0).Result()
+	if err == nil && !ok {
+		err = errors.New("game id already exists")
+	}
+	if err != nil {
+		s.local.Delete(g.ID)
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(c, gamesSet, g.ID)
+	for name := range g.Players {
+		pipe.Set(c, playerKey(name), g.ID, 0)
+	}
+	_, err = pipe.Exec(c)
+	return err
+}
+
+// Adopt makes this process the owner of g: it is cached locally and written
+// to Redis at its current version if Redis doesn't already have it
+func (s *Store) Adopt(g *game.Game) error {
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		return err
+	}
+
+	c, cancel := ctx()
+	defer cancel()
+	pipe := s.rdb.TxPipeline()
+	pipe.SetNX(c, gameKey(g.ID), data, 0)
+	pipe.SAdd(c, gamesSet, g.ID)
+	for name := range g.Players {
+		pipe.Set(c, playerKey(name), g.ID, 0)
+	}
+	if _, err := pipe.Exec(c); err != nil {
+		return err
+	}
+	s.local.Put(g)
+	return nil
+}
+
+func (s *Store) Get(id string) (*game.Game, error) {
+	if g, err := s.local.Get(id); err == nil {
+		return g, nil
+	}
+	return s.load(id)
+}
+
+// load reads a game straight from Redis
+func (s *Store) load(id string) (*game.Game, error) {
+	c, cancel := ctx()
+	defer cancel()
+
+	data, err := s.rdb.Get(c, gameKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, game.ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return game.DecodeGame(data)
+}
+
+// Update does a check-and-set on the stored version inside WATCH/MULTI, so
+// two writers holding the same version can't both win
+func (s *Store) Update(g *game.Game) error {
+	c, cancel := ctx()
+	defer cancel()
+
+	key := gameKey(g.ID)
+	err := s.rdb.Watch(c, func(tx *redis.Tx) error {
+		data, err := tx.Get(c, key).Bytes()
+		if err == redis.Nil {
+			return game.ErrGameNotFound
+		}
+		if err != nil {
+			return err
+		}
+		stored, err := game.DecodeGame(data)
+		if err != nil {
+			return err
+		}
+		if stored.Version != g.Version {
+			return game.ErrVersionConflict
+		}
+
+		next := game.ToRecord(g)
+		next.Version++
+		out, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		ttl := time.Duration(0)
+		if g.Status != "playing" {
+			ttl = finishedTTL
+		}
+		_, err = tx.TxPipelined(c, func(p redis.Pipeliner) error {
+			p.Set(c, key, out, ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return game.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	g.Version++
+	s.local.Put(g)
+	return nil
+}
+
+// FindByPlayer follows the player pointer in Redis, since the player's latest
+// game may be hosted by another node while a finished one is still cached
+// here. The local copy is only used when it is that game.
+func (s *Store) FindByPlayer(username string) (*game.Game, error) {
+	c, cancel := ctx()
+	defer cancel()
+	id, err := s.rdb.Get(c, playerKey(username)).Result()
+	if err == redis.Nil {
+		return nil, game.ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g, err := s.local.Get(id); err == nil {
+		return g, nil
+	}
+	return s.load(id)
+}
+
+// List returns every game Redis knows about, preferring the live local copy
+func (s *Store) List() ([]*game.Game, error) {
+	c, cancel := ctx()
+	defer cancel()
+
+	ids, err := s.rdb.SMembers(c, gamesSet).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = gameKey(id)
+	}
+	vals, err := s.rdb.MGet(c, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*game.Game, 0, len(ids))
+	var expired []interface{}
+	for i, v := range vals {
+		if g, err := s.local.Get(ids[i]); err == nil {
+			res = append(res, g)
+			continue
+		}
+		str, ok := v.(string)
+		if !ok {
+			// The key expired; tidy up the index
+			expired = append(expired, ids[i])
+			continue
+		}
+		if g, err := game.DecodeGame([]byte(str)); err == nil {
+			res = append(res, g)
+		}
+	}
+	if len(expired) > 0 {
+		s.rdb.SRem(c, gamesSet, expired...)
+	}
+	return res, nil
+}
+
+func (s *Store) Delete(id string) error {
+	g, _ := s.Get(id)
+	s.local.Delete(id)
+
+	c, cancel := ctx()
+	defer cancel()
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(c, gameKey(id))
+	pipe.SRem(c, gamesSet, id)
+	if g != nil {
+		for name := range g.Players {
+			// Only clear the pointer if it still refers to this game
+			pipe.Eval(c, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`,
+				[]string{playerKey(name)}, id)
+		}
+	}
+	_, err := pipe.Exec(c)
+	return err
+}
@@ -0,0 +1,101 @@
+package redisstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestStore is a Store on its own miniredis
+func newTestStore(t *testing.T, mr *miniredis.Miniredis) *Store {
+	t.Helper()
+	s := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStore(t *testing.T) {
+	for _, c := range storetest.Cases {
+		t.Run(c.Name, func(t *testing.T) { c.Run(t, newTestStore(t, miniredis.RunT(t))) })
+	}
+}
+
+// Two instances sharing one Redis: the second only sees decoded copies, so
+// the version check in Redis is all that stops it overwriting the owner
+func TestStoreConflictAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	owner, other := newTestStore(t, mr), newTestStore(t, mr)
+
+	g := storetest.NewGame("alice", "bob", time.Now())
+	if err := owner.Add(g); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	copy, err := other.FindByPlayer("alice")
+	if err != nil {
+		t.Fatalf("FindByPlayer on the other instance: %v", err)
+	}
+	if copy == g || copy.ID != g.ID || copy.Version != 1 {
+		t.Fatalf("other instance got %p v%d, want a v1 copy of %s", copy, copy.Version, g.ID)
+	}
+
+	g.Board[5][3] = 1
+	if err := owner.Update(g); err != nil {
+		t.Fatalf("owner Update: %v", err)
+	}
+	copy.Board[5][4] = 2
+	if err := other.Update(copy); !errors.Is(err, game.ErrVersionConflict) {
+		t.Fatalf("Update of a stale copy: err = %v, want ErrVersionConflict", err)
+	}
+
+	fresh, err := other.Get(g.ID)
+	if err != nil {
+		t.Fatalf("Get on the other instance: %v", err)
+	}
+	if fresh.Version != 2 || fresh.Board[5][3] != 1 || fresh.Board[5][4] != 0 {
+		t.Errorf("other instance reads v%d with %v, want the owner's v2", fresh.Version, fresh.Board[5])
+	}
+
+	if err := owner.Delete(g.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := other.FindByPlayer("bob"); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("FindByPlayer on the other instance after Delete: err = %v, want ErrGameNotFound", err)
+	}
+}
+
+// A finished game cached on one node must not hide the player's newer game
+// on another
+func TestFindByPlayerFollowsRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	here, there := newTestStore(t, mr), newTestStore(t, mr)
+
+	old := storetest.NewGame("alice", "bob", time.Now().Add(-time.Minute))
+	if err := here.Add(old); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	old.Status = "finished"
+	if err := here.Update(old); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	live := storetest.NewGame("alice", "carol", time.Now())
+	if err := there.Add(live); err != nil {
+		t.Fatalf("Add on the other node: %v", err)
+	}
+
+	got, err := here.FindByPlayer("alice")
+	if err != nil {
+		t.Fatalf("FindByPlayer: %v", err)
+	}
+	if got.ID != live.ID || got.Status != "playing" {
+		t.Errorf("FindByPlayer = %s (%s), want the live game %s on the other node", got.ID, got.Status, live.ID)
+	}
+	if got, err := here.FindByPlayer("bob"); err != nil || got != old {
+		t.Errorf("FindByPlayer(bob) = %p, %v, want the local copy %p", got, err, old)
+	}
+}
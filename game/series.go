@@ -0,0 +1,42 @@
+package game
+
+// Series links consecutive rematches between the same two players
+type Series struct {
+	ID      string         `json:"id"`
+	GameIDs []string       `json:"gameIds"`
+	Score   map[string]int `json:"score"` // wins per username, plus "draw"
+}
+
+func NewSeries(id string, g *Game) *Series {
+	s := &Series{ID: id, Score: make(map[string]int)}
+	for _, p := range g.Players {
+		s.Score[p.Username] = 0
+	}
+	return s
+}
+
+// Clone is a deep copy of s, or nil
+func (s *Series) Clone() *Series {
+	if s == nil {
+		return nil
+	}
+	cp := &Series{ID: s.ID, GameIDs: append([]string(nil), s.GameIDs...), Score: make(map[string]int, len(s.Score))}
+	for k, v := range s.Score {
+		cp.Score[k] = v
+	}
+	return cp
+}
+
+// Record adds a finished game's result to the running score
+func (s *Series) Record(g *Game) {
+	if g.Winner == "draw" {
+		s.Score["draw"]++
+		return
+	}
+	for _, p := range g.Players {
+		if p.ID == g.Winner {
+			s.Score[p.Username]++
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package game
+
+import "log"
+
+// Snapshotter durably records in-progress games so they survive a restart.
+// Save is called after every store update while the game is being played;
+// Delete once it is over.
+type Snapshotter interface {
+	SaveSnapshot(g *Game) error
+	DeleteSnapshot(id string) error
+	LoadSnapshots() ([]*Game, error)
+}
+
+// Snapshots is nil unless main configures a durable snapshot target
+var Snapshots Snapshotter
+
+// Adopter is implemented by stores that can take over a game loaded from
+// somewhere else (a snapshot, another node) while keeping its version
+type Adopter interface {
+	Adopt(g *Game) error
+}
+
+func snapshot(g *Game) {
+	if Snapshots == nil {
+		return
+	}
+	var err error
+	if g.Status == "playing" {
+		err = Snapshots.SaveSnapshot(g)
+	} else {
+		err = Snapshots.DeleteSnapshot(g.ID)
+	}
+	if err != nil {
+		log.Printf("[SNAPSHOT] Failed to snapshot game %s: %v", g.ID, err)
+	}
+}
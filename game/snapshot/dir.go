@@ -0,0 +1,97 @@
+// Package snapshot keeps one JSON file per in-progress game in a directory.
+// Files are replaced atomically (write, fsync, rename), so a crash leaves
+// either the previous state or the new one, never a torn file.
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"fourinrow/game"
+)
+
+type Dir struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewDir creates the directory if needed
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(id string) string {
+	// Game IDs are UUIDs; refuse anything that could escape the directory
+	return filepath.Join(d.path, filepath.Base(id)+".json")
+}
+
+func (d *Dir) SaveSnapshot(g *game.Game) error {
+	data, err := game.EncodeGame(g)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tmp, err := os.CreateTemp(d.path, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.file(g.ID))
+}
+
+func (d *Dir) DeleteSnapshot(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := os.Remove(d.file(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LoadSnapshots reads every snapshot; unreadable files are skipped
+func (d *Dir) LoadSnapshots() ([]*game.Game, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var games []*game.Game
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(d.path, e.Name()))
+		if err != nil {
+			continue
+		}
+		if g, err := game.DecodeGame(data); err == nil {
+			games = append(games, g)
+		}
+	}
+	return games, nil
+}
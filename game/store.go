@@ -1,40 +1,199 @@
 package game
 
-import "sync"
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+)
 
-type GameStore struct {
-	mu    sync.RWMutex
-	games map[string]*Game
+// Lifecycle limits for games held in memory
+const (
+	// Finished games stay around this long for rematches, chat and reconnects
+	FinishedGrace = 5 * time.Minute
+	// Games with no move and no connected human for this long are reaped
+	AbandonTimeout = 30 * time.Minute
+	// Hard cap on games in memory, finished or not
+	MaxGames = 10000
+)
+
+var (
+	ErrStoreFull       = errors.New("server is at capacity, try again shortly")
+	ErrGameNotFound    = errors.New("game not found")
+	ErrVersionConflict = errors.New("game was modified concurrently")
+)
+
+// GameStore is where games live. Implementations must hand back the same
+// *Game for games this process owns, since the pointer carries the players'
+// live connections and timers.
+type GameStore interface {
+	// Add stores a new game at version 1
+	Add(g *Game) error
+	Get(id string) (*Game, error)
+	// Update saves g if nobody else saved since g.Version was read, then
+	// bumps g.Version. Otherwise it returns ErrVersionConflict.
+	Update(g *Game) error
+	// FindByPlayer returns the player's most recent game, finished or not
+	FindByPlayer(username string) (*Game, error)
+	List() ([]*Game, error)
+	Delete(id string) error
 }
 
-var Store = &GameStore{
-	games: make(map[string]*Game),
+// StoreStats are counters exported on /debug/vars as "game_store"
+type StoreStats struct {
+	Games           int    `json:"games"`
+	Live            int    `json:"live"`
+	Finished        int    `json:"finished"`
+	Added           uint64 `json:"added"`
+	EvictedFinished uint64 `json:"evictedFinished"`
+	ReapedAbandoned uint64 `json:"reapedAbandoned"`
+	RejectedFull    uint64 `json:"rejectedFull"`
 }
 
-func (s *GameStore) AddGame(g *Game) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.games[g.ID] = g
+var counters struct {
+	added, evicted, reaped, rejected atomic.Uint64
 }
 
-func (s *GameStore) GetGame(id string) *Game {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.games[id]
+func Stats(s GameStore) StoreStats {
+	st := StoreStats{
+		Added:           counters.added.Load(),
+		EvictedFinished: counters.evicted.Load(),
+		ReapedAbandoned: counters.reaped.Load(),
+		RejectedFull:    counters.rejected.Load(),
+	}
+	games, _ := s.List()
+	st.Games = len(games)
+	for _, g := range games {
+		g.Lock()
+		if g.Status == "playing" {
+			st.Live++
+		}
+		g.Unlock()
+	}
+	st.Finished = st.Games - st.Live
+	return st
 }
 
 // FindGameByPlayerName finds an active game for reconnection
-func (s *GameStore) FindGameByPlayerName(username string) *Game {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
-    
-    for _, g := range s.games {
-        // Only look for games that are still playing
-        if g.Status == "playing" {
-            if _, ok := g.Players[username]; ok {
-                return g
-            }
-        }
-    }
-    return nil
-}
\ No newline at end of file
+func FindGameByPlayerName(s GameStore, username string) *Game {
+	// A player's active game is always their latest one
+	if g := FindLatestGameByPlayerName(s, username); g != nil && g.Status == "playing" {
+		return g
+	}
+	return nil
+}
+
+// FindLatestGameByPlayerName returns the player's most recent game, finished or not
+func FindLatestGameByPlayerName(s GameStore, username string) *Game {
+	g, err := s.FindByPlayer(username)
+	if err != nil && err != ErrGameNotFound {
+		log.Printf("[STORE] Lookup for %s failed: %v", username, err)
+	}
+	return g
+}
+
+// GetGame returns the game, or nil if the store doesn't have it
+func GetGame(s GameStore, id string) *Game {
+	g, err := s.Get(id)
+	if err != nil && err != ErrGameNotFound {
+		log.Printf("[STORE] Get %s failed: %v", id, err)
+	}
+	return g
+}
+
+// Save writes a changed game back to the store, logging conflicts, and
+// snapshots it if snapshots are enabled
+func Save(s GameStore, g *Game) {
+	if err := s.Update(g); err != nil {
+		log.Printf("[STORE] Failed to save game %s: %v", g.ID, err)
+		return
+	}
+	snapshot(g)
+}
+
+// Register adds a brand new game to the store and takes its first snapshot
+func Register(s GameStore, g *Game) error {
+	if err := s.Add(g); err != nil {
+		return err
+	}
+	snapshot(g)
+	return nil
+}
+
+// Sweep evicts finished games past their grace period and reaps abandoned
+// ones. It returns the abandoned games so the caller can clean up timers
+// and connections; they are already out of the store. Games another cluster
+// node owns are left to that node: through a shared store they are decoded
+// copies, which always look disconnected. node is "" for a single server.
+func Sweep(s GameStore, now time.Time, node string) []*Game {
+	games, err := s.List()
+	if err != nil {
+		log.Printf("[STORE] Sweep failed: %v", err)
+		return nil
+	}
+
+	var reaped []*Game
+	for _, g := range games {
+		g.Lock()
+		if g.Owner != "" && g.Owner != node {
+			g.Unlock()
+			continue
+		}
+		expired := g.Status == "finished" && !g.FinishedAt.IsZero() && now.Sub(g.FinishedAt) > FinishedGrace
+		abandoned := g.Status != "finished" && isAbandoned(g, now)
+		g.Unlock()
+
+		switch {
+		case expired:
+			s.Delete(g.ID)
+			counters.evicted.Add(1)
+		case abandoned:
+			s.Delete(g.ID)
+			counters.reaped.Add(1)
+			reaped = append(reaped, g)
+		}
+	}
+	return reaped
+}
+
+// isAbandoned reports whether nobody human is connected to g and it hasn't
+// moved for AbandonTimeout. Callers hold g's lock.
+func isAbandoned(g *Game, now time.Time) bool {
+	for _, p := range g.Players {
+		if p.IsConnected && !p.IsBot {
+			return false
+		}
+	}
+	last := g.CreatedAt
+	if n := len(g.Moves); n > 0 {
+		last = g.Moves[n-1].At
+	}
+	return now.Sub(last) > AbandonTimeout
+}
+
+// SnapshotLive copies a summary of every game in progress, newest first.
+// Callers can filter, page and encode the result without touching the store.
+func SnapshotLive(s GameStore) []GameSummary {
+	games, err := s.List()
+	if err != nil {
+		log.Printf("[STORE] List failed: %v", err)
+		return nil
+	}
+
+	res := make([]GameSummary, 0, len(games))
+	for _, g := range games {
+		g.Lock()
+		if g.Status == "playing" {
+			res = append(res, Summarize(g))
+		}
+		g.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].StartedAt.Equal(res[j].StartedAt) {
+			return res[i].StartedAt.After(res[j].StartedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
@@ -0,0 +1,66 @@
+package game_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+func TestSweepLeavesOtherNodesGames(t *testing.T) {
+	s := game.NewMemoryStore(game.MaxGames)
+	now := time.Now()
+	mine := storetest.NewGame("alice", "bob", now.Add(-2*game.AbandonTimeout))
+	mine.Owner = "node-a"
+	theirs := storetest.NewGame("carol", "dave", now.Add(-2*game.AbandonTimeout))
+	theirs.Owner = "node-b"
+	for _, g := range []*game.Game{mine, theirs} {
+		if err := s.Add(g); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	reaped := game.Sweep(s, now, "node-a")
+	if len(reaped) != 1 || reaped[0] != mine {
+		t.Fatalf("node-a reaped %d games, want only its own", len(reaped))
+	}
+	if _, err := s.Get(theirs.ID); err != nil {
+		t.Fatalf("node-a deleted node-b's game: %v", err)
+	}
+	if reaped := game.Sweep(s, now, "node-b"); len(reaped) != 1 || reaped[0] != theirs {
+		t.Fatalf("node-b reaped %d games, want its own", len(reaped))
+	}
+}
+
+// The directory, stats and janitor read live games while moves change them
+func TestStoreReadersLockGames(t *testing.T) {
+	s := game.NewMemoryStore(game.MaxGames)
+	g := storetest.NewGame("alice", "bob", time.Now())
+	if err := s.Add(g); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			g.Lock()
+			g.Moves = append(g.Moves, game.Move{Ply: i + 1, At: time.Now()})
+			g.Players[fmt.Sprintf("seat-%d", i)] = &game.Player{Color: 3}
+			g.SpectatorCount = i
+			g.Unlock()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			game.SnapshotLive(s)
+			game.Stats(s)
+			game.Sweep(s, time.Now(), "")
+		}
+	}
+}
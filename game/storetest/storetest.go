@@ -0,0 +1,176 @@
+// Package storetest is the conformance suite every game.GameStore must pass.
+// Each case gets a fresh, empty store:
+//
+//	for _, c := range storetest.Cases {
+//		t.Run(c.Name, func(t *testing.T) { c.Run(t, newStore()) })
+//	}
+package storetest
+
+import (
+	"errors"
+	"time"
+
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+// T is the part of *testing.T the cases use. Fatalf must stop the case.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+type Case struct {
+	Name string
+	Run  func(t T, s game.GameStore)
+}
+
+var Cases = []Case{
+	{"AddAndGet", testAddAndGet},
+	{"VersionConflict", testVersionConflict},
+	{"FindByPlayer", testFindByPlayer},
+	{"Delete", testDelete},
+}
+
+// NewGame is a game in progress between two fresh players, started at
+// createdAt
+func NewGame(a, b string, createdAt time.Time) *game.Game {
+	g := &game.Game{
+		ID: uuid.NewString(), Players: map[string]*game.Player{},
+		Status: "playing", Variant: "classic", CreatedAt: createdAt,
+	}
+	for i, name := range []string{a, b} {
+		p := &game.Player{ID: uuid.NewString(), Username: name, Color: i + 1, GameID: g.ID}
+		g.Players[name] = p
+	}
+	g.CurrentTurn = g.Players[a].ID
+	return g
+}
+
+func add(t T, s game.GameStore, g *game.Game) {
+	t.Helper()
+	if err := s.Add(g); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+}
+
+func testAddAndGet(t T, s game.GameStore) {
+	g := NewGame("alice", "bob", time.Now())
+	add(t, s, g)
+	if g.Version != 1 {
+		t.Errorf("version after Add = %d, want 1", g.Version)
+	}
+
+	got, err := s.Get(g.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ID != g.ID || got.Version != 1 || len(got.Players) != 2 {
+		t.Errorf("Get = %s v%d with %d players, want %s v1 with 2", got.ID, got.Version, len(got.Players), g.ID)
+	}
+	if _, err := s.Get(uuid.NewString()); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("Get of a missing game: err = %v, want ErrGameNotFound", err)
+	}
+}
+
+func testVersionConflict(t T, s game.GameStore) {
+	g := NewGame("alice", "bob", time.Now())
+	add(t, s, g)
+
+	// A second writer that read the game at version 1
+	stale := NewGame("alice", "bob", g.CreatedAt)
+	stale.ID, stale.Version = g.ID, g.Version
+
+	g.Board[5][0] = 1
+	if err := s.Update(g); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if g.Version != 2 {
+		t.Errorf("version after Update = %d, want 2", g.Version)
+	}
+
+	stale.Board[5][6] = 1
+	if err := s.Update(stale); !errors.Is(err, game.ErrVersionConflict) {
+		t.Fatalf("Update from version 1 after another writer: err = %v, want ErrVersionConflict", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("a rejected Update bumped the version to %d", stale.Version)
+	}
+	got, err := s.Get(g.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Version != 2 || got.Board[5][0] != 1 || got.Board[5][6] != 0 {
+		t.Errorf("stored v%d with board %v, want the first writer's v2", got.Version, got.Board[5])
+	}
+
+	// Retrying from the current version succeeds
+	if err := s.Update(g); err != nil || g.Version != 3 {
+		t.Errorf("Update from the current version: v%d, err = %v", g.Version, err)
+	}
+	if err := s.Update(NewGame("carol", "dave", time.Now())); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("Update of a game never added: err = %v, want ErrGameNotFound", err)
+	}
+}
+
+func testFindByPlayer(t T, s game.GameStore) {
+	now := time.Now()
+	first := NewGame("alice", "bob", now.Add(-time.Minute))
+	first.Status = "finished"
+	add(t, s, first)
+	second := NewGame("carol", "alice", now)
+	add(t, s, second)
+
+	for _, tc := range []struct {
+		username string
+		want     *game.Game
+	}{
+		{"alice", second}, // the most recent game, whatever the seat
+		{"bob", first},    // finished games count too
+		{"carol", second},
+	} {
+		got, err := s.FindByPlayer(tc.username)
+		if err != nil {
+			t.Fatalf("FindByPlayer(%s): %v", tc.username, err)
+		}
+		if got.ID != tc.want.ID {
+			t.Errorf("FindByPlayer(%s) = %s, want %s", tc.username, got.ID, tc.want.ID)
+		}
+	}
+	if _, err := s.FindByPlayer("nobody"); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("FindByPlayer for a player without games: err = %v, want ErrGameNotFound", err)
+	}
+}
+
+func testDelete(t T, s game.GameStore) {
+	now := time.Now()
+	old := NewGame("alice", "bob", now.Add(-time.Minute))
+	add(t, s, old)
+	g := NewGame("alice", "carol", now)
+	add(t, s, g)
+
+	if err := s.Delete(g.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(g.ID); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrGameNotFound", err)
+	}
+	if _, err := s.FindByPlayer("carol"); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("FindByPlayer(carol) after Delete: err = %v, want ErrGameNotFound", err)
+	}
+	games, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(games) != 1 || games[0].ID != old.ID {
+		t.Errorf("List after Delete has %d games, want only the older one", len(games))
+	}
+	if err := s.Delete(g.ID); err != nil {
+		t.Errorf("deleting a game twice: %v", err)
+	}
+	if err := s.Update(g); !errors.Is(err, game.ErrGameNotFound) {
+		t.Errorf("Update after Delete: err = %v, want ErrGameNotFound", err)
+	}
+}
@@ -0,0 +1,46 @@
+package game
+
+import (
+	"sort"
+	"time"
+)
+
+// GameSummary is a detached copy of the parts of a game the directory shows.
+// It is safe to use after the store lock is released.
+type GameSummary struct {
+	ID             string          `json:"id"`
+	Variant        string          `json:"variant"`
+	Status         string          `json:"status"`
+	Players        []PlayerSummary `json:"players"`
+	MoveCount      int             `json:"moveCount"`
+	SpectatorCount int             `json:"spectatorCount"`
+	StartedAt      time.Time       `json:"startedAt"`
+	IsBotGame      bool            `json:"isBotGame"`
+}
+
+type PlayerSummary struct {
+	Username string  `json:"username"`
+	Color    int     `json:"color"`
+	Rating   float64 `json:"rating,omitempty"`
+	IsBot    bool    `json:"isBot"`
+}
+
+// Summarize copies g's directory entry. Callers hold g's lock.
+func Summarize(g *Game) GameSummary {
+	s := GameSummary{
+		ID:             g.ID,
+		Variant:        g.Variant,
+		Status:         g.Status,
+		MoveCount:      len(g.Moves),
+		SpectatorCount: g.SpectatorCount,
+		StartedAt:      g.CreatedAt,
+	}
+	for _, p := range g.Players {
+		s.Players = append(s.Players, PlayerSummary{Username: p.Username, Color: p.Color, Rating: p.Rating, IsBot: p.IsBot})
+		if p.IsBot {
+			s.IsBotGame = true
+		}
+	}
+	sort.Slice(s.Players, func(i, j int) bool { return s.Players[i].Color < s.Players[j].Color })
+	return s
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"fourinrow/analytics"
+	"fourinrow/cluster"
+	"fourinrow/config"
 	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/redisstore"
+	"fourinrow/game/snapshot"
 	"fourinrow/server"
 )
 
@@ -38,50 +46,149 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	// 0. Load settings: defaults < config file < environment < flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// 1. Initialize Database
-	db.InitDB()
-
-	// 2. Initialize Analytics
-	// Check for environment variable first (for Cloud), otherwise default to localhost
-	kafkaUrl := os.Getenv("KAFKA_BROKER")
-	var kafkaBrokers []string
-	if kafkaUrl != "" {
-		kafkaBrokers = []string{kafkaUrl}
-	} else {
-		kafkaBrokers = []string{"localhost:9092"}
+	db.InitDB(cfg)
+
+	// 2. Initialize Analytics (falls back to a stub without Kafka)
+	analytics.Producer = analytics.Open(cfg)
+
+	// Events saved with games wait in the outbox until the relay gets them
+	// to Kafka, retrying while it is down. Without a producer there is
+	// nowhere to send them, so they stay put until a start that has one.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	switch {
+	case db.Repo == nil:
+		close(relayDone)
+	case !analytics.HasKafka(analytics.Producer):
+		log.Println("[OUTBOX] No Kafka producer configured, not starting the outbox relay")
+		close(relayDone)
+	default:
+		relay := analytics.NewRelay(db.Repo, analytics.NewKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic))
+		go func() {
+			relay.Run(relayCtx)
+			close(relayDone)
+		}()
+	}
+
+	// Games live in memory unless a Redis-compatible store is configured
+	var store game.GameStore = game.NewMemoryStore(game.MaxGames)
+	if url := cfg.RedisURL; url != "" {
+		rs, err := redisstore.Dial(url)
+		if err != nil {
+			log.Printf("[STORE] ⚠️ Redis unavailable: %v (keeping games in memory)", err)
+		} else {
+			log.Println("[STORE] ✅ Connected to Redis game store")
+			store = rs
+			defer rs.Close()
+		}
 	}
 
-	// --- FIX START: TYPED NIL CHECK ---
-	// We capture the pointer returned by NewKafkaProducer directly.
-	// We MUST check if this specific pointer is nil BEFORE assigning it to the interface.
-	kafkaProducer := analytics.NewKafkaProducer(kafkaBrokers, "game-events")
-
-	if kafkaProducer != nil {
-		// It worked! Assign the valid pointer to the global interface
-		analytics.Producer = kafkaProducer
-	} else {
-		// It failed! Use the Stub instead
-		analytics.Producer = analytics.NewStubProducer()
+	srv := server.New(cfg, store)
+	srv.PublishVars()
+
+	// Several instances can share one matchmaking queue and host each
+	// other's games, talking over Redis pub/sub
+	if id := cfg.ClusterNodeID; id != "" {
+		if broker, err := cluster.DialRedis(cfg.RedisURL); err != nil {
+			log.Printf("[CLUSTER] ⚠️ Broker unavailable: %v (running as a single node)", err)
+		} else {
+			node := cluster.NewNode(id, broker)
+			srv.EnableCluster(node)
+			if err := node.Start(); err != nil {
+				log.Fatalf("[CLUSTER] Could not join cluster: %v", err)
+			}
+			defer node.Stop()
+			defer broker.Close()
+		}
+	}
+
+	// Snapshot in-progress games so a restart doesn't lose them: to a
+	// directory if SNAPSHOT_DIR is set, otherwise to the database when there
+	// is a real one (the in-memory repository wouldn't survive the restart)
+	if dir := cfg.SnapshotDir; dir != "" {
+		snaps, err := snapshot.NewDir(dir)
+		if err != nil {
+			log.Printf("[SNAPSHOT] ⚠️ Cannot use %s: %v (snapshots disabled)", dir, err)
+		} else {
+			game.Snapshots = snaps
+		}
+	} else if db.Repo != nil && cfg.DatabaseURL != "" {
+		game.Snapshots = db.Repo
+	}
+	if game.Snapshots != nil {
+		srv.RestoreGames(game.Snapshots)
 	}
-	// --- FIX END ---
-	
-    defer analytics.Producer.Close()
+
+	// Keep the in-memory game store bounded (counters on /debug/vars)
+	go srv.RunStoreJanitor(server.JanitorInterval)
 
 	// 3. Setup Routes
-	http.HandleFunc("/ws", server.WebSocketHandler)
-	http.HandleFunc("/leaderboard", server.LeaderboardHandler)
+	http.HandleFunc("/ws", srv.WebSocketHandler)
+	http.HandleFunc("/leaderboard", srv.LeaderboardHandler)
+	http.HandleFunc("/api/guest", srv.GuestHandler)
+	http.HandleFunc("/api/accounts", srv.AccountsHandler)
+	http.HandleFunc("/api/rooms", srv.RoomsHandler)
+	http.HandleFunc("/api/games/live", srv.LiveGamesHandler)
+	http.HandleFunc("/api/games/", server.GameHandler)
+	http.HandleFunc("/api/players/", server.PlayersHandler)
 
 	// 4. Serve Frontend
 	spa := spaHandler{staticPath: "./client/dist", indexPath: "index.html"}
 	http.Handle("/", spa)
 
 	// 5. Start Server (Cloud Compatible)
-	// Render/Heroku provide the PORT variable. We must use it.
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5000" // Default for local development
-	}
-	
+	// Render/Heroku provide the PORT variable, which config picks up
+	port := cfg.Port
+	httpServer := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 	log.Printf("Server running on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+
+	// 6. Drain on SIGTERM (deploys) or Ctrl-C instead of dropping games
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	sig := <-stop
+	shutdownTimeout := cfg.ShutdownTimeout.Duration
+	log.Printf("[SHUTDOWN] Received %v, shutting down within %s", sig, shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Games get the deadline minus a fifth kept for flushing analytics and the DB
+	gamesCtx, cancelGames := context.WithTimeout(ctx, shutdownTimeout-shutdownTimeout/5)
+	srv.Shutdown(gamesCtx)
+	cancelGames()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("[SHUTDOWN] HTTP server: %v", err)
+	}
+	closeWithin(ctx, "analytics", func() error { return analytics.Producer.Close(ctx) })
+	// Unsent outbox events stay in the database for the next start
+	closeWithin(ctx, "outbox relay", func() error { stopRelay(); <-relayDone; return nil })
+	closeWithin(ctx, "database", db.Close)
+	log.Println("[SHUTDOWN] Bye")
+}
+
+// closeWithin runs close but gives up waiting once ctx expires
+func closeWithin(ctx context.Context, what string, close func() error) {
+	done := make(chan error, 1)
+	go func() { done <- close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("[SHUTDOWN] Closing %s: %v", what, err)
+		}
+	case <-ctx.Done():
+		log.Printf("[SHUTDOWN] ⚠️ Gave up waiting for %s to close", what)
+	}
 }
\ No newline at end of file
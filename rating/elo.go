@@ -0,0 +1,17 @@
+package rating
+
+import "math"
+
+// Elo is the classic Elo system, kept as a simpler fallback to Glicko-2.
+// K is the maximum rating change per game.
+type Elo struct {
+	K float64
+}
+
+func (s Elo) Rate(a, b Rating, scoreA float64) (Rating, Rating) {
+	expA := 1 / (1 + math.Pow(10, (b.Value-a.Value)/400))
+	delta := s.K * (scoreA - expA)
+	a.Value += delta
+	b.Value -= delta
+	return a, b
+}
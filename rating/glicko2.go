@@ -0,0 +1,106 @@
+package rating
+
+import "math"
+
+// glickoScale converts between the Glicko display scale and Glicko-2's internal one
+const glickoScale = 173.7178
+
+// convergence tolerance for the volatility iteration
+const epsilon = 0.000001
+
+// Glicko2 implements Mark Glickman's Glicko-2 system, treating every game as
+// its own rating period. Tau constrains how fast volatility can change;
+// sensible values are between 0.3 and 1.2.
+type Glicko2 struct {
+	Tau float64
+}
+
+func (s Glicko2) Rate(a, b Rating, scoreA float64) (Rating, Rating) {
+	return s.update(a, []result{{b, scoreA}}), s.update(b, []result{{a, 1 - scoreA}})
+}
+
+// result is one game in a rating period, scored from the rated player's side
+type result struct {
+	opp   Rating
+	score float64
+}
+
+// update rates p over one rating period, following the steps in Glickman's
+// "Example of the Glicko-2 system"
+func (s Glicko2) update(p Rating, results []result) Rating {
+	// Step 2: convert to the Glicko-2 scale
+	mu := (p.Value - DefaultValue) / glickoScale
+	phi := p.RD / glickoScale
+
+	// Steps 3-4: estimated variance and improvement
+	var vInv, sum float64
+	for _, r := range results {
+		muJ := (r.opp.Value - DefaultValue) / glickoScale
+		gJ := g(r.opp.RD / glickoScale)
+		e := expected(mu, muJ, r.opp.RD/glickoScale)
+		vInv += gJ * gJ * e * (1 - e)
+		sum += gJ * (r.score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// Step 5: new volatility
+	sigma := s.volatility(p.Volatility, phi, v, delta)
+
+	// Steps 6-7: new deviation and rating
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*sum
+
+	// Step 8: back to the Glicko scale
+	return Rating{
+		Value:      newMu*glickoScale + DefaultValue,
+		RD:         math.Min(newPhi*glickoScale, DefaultRD),
+		Volatility: sigma,
+	}
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muJ, phiJ float64) float64 {
+	return 1 / (1 + math.Exp(-g(phiJ)*(mu-muJ)))
+}
+
+// volatility solves for the new sigma with the Illinois variant of regula falsi
+func (s Glicko2) volatility(sigma, phi, v, delta float64) float64 {
+	tau := s.Tau
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		num := ex * (delta*delta - phi*phi - v - ex)
+		den := 2 * math.Pow(phi*phi+v+ex, 2)
+		return num/den - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,35 @@
+package rating
+
+// Rating is a player's skill estimate. RD (rating deviation) and Volatility
+// are only meaningful for Glicko-2; Elo leaves them untouched.
+type Rating struct {
+	Value      float64 `json:"rating"`
+	RD         float64 `json:"rd"`
+	Volatility float64 `json:"volatility"`
+}
+
+const (
+	DefaultValue      = 1500.0
+	DefaultRD         = 350.0
+	DefaultVolatility = 0.06
+)
+
+// New returns the rating every unrated player starts with
+func New() Rating {
+	return Rating{Value: DefaultValue, RD: DefaultRD, Volatility: DefaultVolatility}
+}
+
+// Scores for a single game, from the first player's point of view
+const (
+	Loss = 0.0
+	Draw = 0.5
+	Win  = 1.0
+)
+
+// System turns one game result into new ratings for both players
+type System interface {
+	Rate(a, b Rating, scoreA float64) (Rating, Rating)
+}
+
+// Default is the rating system used for rated games
+var Default System = Glicko2{Tau: 0.5}
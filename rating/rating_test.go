@@ -0,0 +1,60 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tol float64) bool { return math.Abs(got-want) <= tol }
+
+// The worked example from Glickman's "Example of the Glicko-2 system"
+func TestGlicko2WorkedExample(t *testing.T) {
+	p := Rating{Value: 1500, RD: 200, Volatility: 0.06}
+	got := Glicko2{Tau: 0.5}.update(p, []result{
+		{Rating{Value: 1400, RD: 30}, Win},
+		{Rating{Value: 1550, RD: 100}, Loss},
+		{Rating{Value: 1700, RD: 300}, Loss},
+	})
+	if !near(got.Value, 1464.06, 0.01) || !near(got.RD, 151.52, 0.01) || !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("rating after the example period = %.2f/%.2f/%.5f, want 1464.06/151.52/0.05999", got.Value, got.RD, got.Volatility)
+	}
+}
+
+func TestGlicko2Rate(t *testing.T) {
+	s := Glicko2{Tau: 0.5}
+	a, b := s.Rate(New(), New(), Win)
+	if a.Value <= DefaultValue || b.Value >= DefaultValue || !near(a.Value-DefaultValue, DefaultValue-b.Value, 1e-9) {
+		t.Errorf("equal players after a win = %.2f and %.2f, want symmetric gain and loss", a.Value, b.Value)
+	}
+	if a.RD >= DefaultRD || b.RD >= DefaultRD {
+		t.Errorf("RD after a game = %.2f and %.2f, want below %v", a.RD, b.RD, DefaultRD)
+	}
+
+	a, b = s.Rate(New(), New(), Draw)
+	if !near(a.Value, DefaultValue, 1e-9) || !near(b.Value, DefaultValue, 1e-9) {
+		t.Errorf("equal players after a draw = %.2f and %.2f, want unchanged", a.Value, b.Value)
+	}
+}
+
+func TestElo(t *testing.T) {
+	s := Elo{K: 32}
+	for _, tc := range []struct {
+		a, b, score float64
+		want        float64 // a's change
+	}{
+		{1500, 1500, Win, 16},                  // expected 0.5
+		{1500, 1500, Draw, 0},                  // expected 0.5
+		{1500, 1900, Win, 32 * (1 - 1.0/11)},   // expected 1/(1+10)
+		{1900, 1500, Win, 32 * (1 - 10.0/11)},  // expected 10/11
+		{1900, 1500, Loss, 32 * (0 - 10.0/11)}, // a big upset costs nearly K
+		{1700, 1500, Draw, 32 * (0.5 - 1/(1+math.Pow(10, -0.5)))},
+	} {
+		a, b := s.Rate(Rating{Value: tc.a}, Rating{Value: tc.b}, tc.score)
+		if !near(a.Value-tc.a, tc.want, 1e-9) || !near(tc.b-b.Value, tc.want, 1e-9) {
+			t.Errorf("%v vs %v scoring %v: changes %+.3f/%+.3f, want ±%.3f", tc.a, tc.b, tc.score, a.Value-tc.a, b.Value-tc.b, tc.want)
+		}
+	}
+	if a, _ := (Elo{K: 16}).Rate(New(), New(), Win); !near(a.Value, 1508, 1e-9) {
+		t.Errorf("K=16 win between equals = %v, want 1508", a.Value)
+	}
+}
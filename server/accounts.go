@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"fourinrow/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_]{3,20}$`)
+
+type createAccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type createAccountResponse struct {
+	Username    string `json:"username"`
+	MergedGuest string `json:"mergedGuest,omitempty"`
+}
+
+// AccountsHandler creates a registered account. If the caller is playing as a
+// guest, their history is merged into the new account and the cookie dropped.
+func (s *Server) AccountsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if db.Repo == nil {
+		http.Error(w, "DB unavailable", 503)
+		return
+	}
+
+	var req createAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// 1. Validate
+	if !validUsername.MatchString(req.Username) || strings.HasPrefix(req.Username, GuestPrefix) {
+		http.Error(w, "username must be 3-20 letters, digits or underscores", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < 8 {
+		http.Error(w, "password must be at least 8 characters", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// 2. Create the account, merging the guest if there is one
+	guestID := ""
+	if guest, ok := s.guestFromRequest(r); ok {
+		guestID = guest.ID
+	}
+
+	account, err := db.Repo.CreateAccount(req.Username, string(hash), guestID)
+	if errors.Is(err, db.ErrGuestNotFound) {
+		// The cookie points at a guest we never recorded; nothing to merge
+		guestID = ""
+		account, err = db.Repo.CreateAccount(req.Username, string(hash), "")
+	}
+	if errors.Is(err, db.ErrAccountExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to create account %s: %v", req.Username, err)
+		http.Error(w, "failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	resp := createAccountResponse{Username: account.Username}
+	if guestID != "" {
+		log.Printf("[AUTH] Guest %s upgraded to account %s (%d games merged)", guestID, account.Username, account.MergedGames)
+		clearGuestCookie(w)
+		if account.MergedGames > 0 {
+			resp.MergedGuest = guestID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
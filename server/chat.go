@@ -0,0 +1,247 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"fourinrow/db"
+	"fourinrow/game"
+)
+
+const (
+	MaxChatLength = 200
+	// At most chatBurst messages (chat and emotes together) per chatWindow
+	chatBurst  = 5
+	chatWindow = 10 * time.Second
+)
+
+// Emotes are the quick reactions a player can send without typing
+var Emotes = map[string]bool{
+	"hello": true, "gg": true, "wp": true, "oops": true, "thinking": true, "wow": true,
+}
+
+var (
+	ErrChatTooLong  = errors.New("message is too long")
+	ErrChatEmpty    = errors.New("message is empty")
+	ErrChatTooFast  = errors.New("you are sending messages too quickly")
+	ErrUnknownEmote = errors.New("unknown emote")
+	ErrNoChatGame   = errors.New("you are not in a game")
+)
+
+// ChatFilter is the profanity hook. It returns the text to relay, or false
+// to drop the message entirely. Swap it out for a real filter service.
+var ChatFilter = MaskProfanity
+
+var profanity = regexp.MustCompile(`(?i)\b(damn|hell|crap|shit|fuck\w*|bitch\w*|ass(hole)?)\b`)
+
+// MaskProfanity replaces blocklisted words with asterisks
+func MaskProfanity(text string) (string, bool) {
+	return profanity.ReplaceAllStringFunc(text, func(w string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(w))
+	}), true
+}
+
+// ChatService relays chat in srv's games and tracks per-player rate limits
+// and mutes
+type ChatService struct {
+	srv       *Server
+	mu        sync.Mutex
+	sent      map[string][]time.Time     // username -> recent send times
+	lastSweep time.Time                  // when idle users were last dropped from sent
+	muted     map[string]map[string]bool // username -> users they muted this session
+	now       func() time.Time
+}
+
+func NewChatService(srv *Server) *ChatService {
+	return &ChatService{
+		srv:   srv,
+		sent:  make(map[string][]time.Time),
+		muted: make(map[string]map[string]bool),
+		now:   time.Now,
+	}
+}
+
+// allow applies the sliding-window rate limit
+func (c *ChatService) allow(username string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+	recent := c.sent[username][:0]
+	for _, t := range c.sent[username] {
+		if now.Sub(t) < chatWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= chatBurst {
+		c.sent[username] = recent
+		return false
+	}
+	c.sent[username] = append(recent, now)
+	return true
+}
+
+// sweep forgets users who haven't sent anything for a whole window, at most
+// once a window. Callers hold c.mu.
+func (c *ChatService) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < chatWindow {
+		return
+	}
+	c.lastSweep = now
+	for username, times := range c.sent {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= chatWindow {
+			delete(c.sent, username)
+		}
+	}
+}
+
+// Mute hides target's messages from username for the rest of the session
+func (c *ChatService) Mute(username, target string, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.muted[username] == nil {
+		c.muted[username] = make(map[string]bool)
+	}
+	if on {
+		c.muted[username][target] = true
+	} else {
+		delete(c.muted[username], target)
+	}
+}
+
+func (c *ChatService) isMuted(username, from string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.muted[username][from]
+}
+
+// Block hides target's messages in every future game too
+func (c *ChatService) Block(username, target string, on bool) error {
+	c.Mute(username, target, on)
+	if db.Repo == nil {
+		return nil
+	}
+	return db.Repo.SetBlocked(username, target, on)
+}
+
+func (c *ChatService) isBlocked(username, from string) bool {
+	if db.Repo == nil {
+		return false
+	}
+	blocked, err := db.Repo.IsBlocked(username, from)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to check block list: %v", err)
+	}
+	return blocked
+}
+
+// Send validates, filters and relays a chat line or emote from username to
+// their opponent and any spectators of their current (or just-finished) game
+func (c *ChatService) Send(username, kind, text string) error {
+	g := game.FindLatestGameByPlayerName(c.srv.store, username)
+	if g == nil {
+		return ErrNoChatGame
+	}
+
+	switch kind {
+	case "emote":
+		if !Emotes[text] {
+			return ErrUnknownEmote
+		}
+	default:
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return ErrChatEmpty
+		}
+		if utf8.RuneCountInString(text) > MaxChatLength {
+			return ErrChatTooLong
+		}
+		filtered, ok := ChatFilter(text)
+		if !ok {
+			return nil
+		}
+		text = filtered
+	}
+	if !c.allow(username) {
+		return ErrChatTooFast
+	}
+
+	// Block lists live in the database, so look them up before taking the
+	// game's lock rather than holding up moves behind the queries
+	g.Lock()
+	var others []string
+	for _, p := range g.Players {
+		if !p.IsBot && p.Username != username {
+			others = append(others, p.Username)
+		}
+	}
+	g.Unlock()
+	blocked := make(map[string]bool)
+	for _, other := range others {
+		blocked[other] = c.isBlocked(other, username)
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	msg := game.ChatMessage{From: username, Kind: kind, Text: text, SentAt: c.now()}
+	g.Chat = append(g.Chat, msg)
+	out := game.WSMessage{Type: kind, Payload: msg}
+
+	for _, p := range g.Players {
+		if p.IsBot || !p.IsConnected {
+			continue
+		}
+		if p.Username != username && (c.isMuted(p.Username, username) || blocked[p.Username]) {
+			continue
+		}
+		p.Conn.WriteJSON(out)
+	}
+	c.srv.spectators.Broadcast(g.ID, out)
+
+	// HandleGameOver already saved the game, so log post-game chat on its own
+	if g.Status == "finished" && db.Repo != nil {
+		if err := db.Repo.SaveChatMessage(g.ID, msg); err != nil {
+			log.Printf("[DB ERROR] Failed to save chat for game %s: %v", g.ID, err)
+		}
+	}
+	return nil
+}
+
+// handleMessage dispatches the chat-related websocket messages:
+//
+//	{"type": "chat",  "payload": {"text": "good luck"}}
+//	{"type": "emote", "payload": {"emote": "gg"}}
+//	{"type": "mute",  "payload": {"username": "bob", "on": true}}
+//	{"type": "block", "payload": {"username": "bob", "on": true}}
+func (c *ChatService) handleMessage(username string, msg game.WSMessage) error {
+	payload, _ := msg.Payload.(map[string]interface{})
+	str := func(k string) string { v, _ := payload[k].(string); return v }
+
+	switch msg.Type {
+	case "chat":
+		return c.Send(username, "chat", str("text"))
+	case "emote":
+		return c.Send(username, "emote", str("emote"))
+	}
+
+	target := str("username")
+	if target == "" || target == username {
+		return errors.New("invalid username")
+	}
+	on, ok := payload["on"].(bool)
+	if !ok {
+		on = true
+	}
+	if msg.Type == "mute" {
+		c.Mute(username, target, on)
+		return nil
+	}
+	return c.Block(username, target, on)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"fourinrow/config"
+	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+func TestChatRateLimitForgetsIdleUsers(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c := NewChatService(newServer(config.Default(), game.NewMemoryStore(game.MaxGames)))
+	c.now = clock.now
+
+	for i := 0; i < chatBurst; i++ {
+		if !c.allow("alice") {
+			t.Fatalf("message %d refused inside the burst", i+1)
+		}
+	}
+	if c.allow("alice") {
+		t.Fatal("message past the burst allowed")
+	}
+
+	clock.advance(chatWindow)
+	if !c.allow("bob") {
+		t.Fatal("bob refused")
+	}
+	if _, ok := c.sent["alice"]; ok {
+		t.Error("alice is still tracked after a window without messages")
+	}
+	if len(c.sent["bob"]) != 1 {
+		t.Errorf("bob has %d recent messages, want 1", len(c.sent["bob"]))
+	}
+}
+
+// slowBlocks is a repository whose block list lookups wait for release
+type slowBlocks struct {
+	db.Repository
+	release chan struct{}
+}
+
+func (r *slowBlocks) IsBlocked(username, target string) (bool, error) {
+	<-r.release
+	return username == "block-bob", nil
+}
+
+func TestChatLooksUpBlocksOutsideGameLock(t *testing.T) {
+	repo := &slowBlocks{release: make(chan struct{})}
+	prev := db.Repo
+	db.Repo = repo
+	t.Cleanup(func() { db.Repo = prev })
+
+	srv := newServer(config.Default(), game.NewMemoryStore(game.MaxGames))
+	g := storetest.NewGame("block-alice", "block-bob", time.Now())
+	bob := &recordConn{}
+	for _, p := range g.Players {
+		p.IsConnected, p.Conn = true, &recordConn{}
+	}
+	g.Players["block-bob"].Conn = bob
+	if err := srv.store.Add(g); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	sent := make(chan error, 1)
+	go func() { sent <- srv.chat.Send("block-alice", "chat", "hi") }()
+
+	// Moves can still take the lock while the block list is being read
+	locked := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		g.Lock()
+		g.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the game lock was held during the block list lookup")
+	}
+
+	close(repo.release)
+	if err := <-sent; err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	bob.mu.Lock()
+	defer bob.mu.Unlock()
+	if len(bob.msgs) != 0 {
+		t.Errorf("bob blocked alice but got %v", bob.msgs)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"fourinrow/game"
+)
+
+// armClock (re)starts the timer that ends the game when the player to move
+// runs out of time. Callers hold g's lock.
+func (s *Server) armClock(g *game.Game) {
+	stopClock(g)
+	if g.Clock == nil || g.Status != "playing" {
+		return
+	}
+
+	turn := g.CurrentTurn
+	left := g.Clock.Left(turn, turn, time.Now())
+	var timer *time.Timer
+	timer = time.AfterFunc(left, func() {
+		g.Lock()
+		defer g.Unlock()
+		// Only flag if this is still the running timer, i.e. no move or
+		// game over got the lock first
+		if g.ClockTimer == timer && g.Status == "playing" && g.CurrentTurn == turn {
+			s.flagPlayer(g, turn)
+		}
+	})
+	g.ClockTimer = timer
+}
+
+func stopClock(g *game.Game) {
+	if g.ClockTimer != nil {
+		g.ClockTimer.Stop()
+		g.ClockTimer = nil
+	}
+}
+
+// flagPlayer ends the game on time: the opponent of playerID wins. Callers
+// hold g's lock.
+func (s *Server) flagPlayer(g *game.Game, playerID string) {
+	log.Printf("[GAME] Player %s ran out of time in game %s", playerID, g.ID)
+	g.Clock.Punch(playerID, time.Now())
+	g.Status = "finished"
+	g.EndReason = game.EndTimeout
+	for _, p := range g.Players {
+		if p.ID != playerID {
+			g.Winner = p.ID
+			break
+		}
+	}
+	s.HandleGameOver(g)
+}
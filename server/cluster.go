@@ -0,0 +1,330 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"fourinrow/cluster"
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+// Envelope kinds exchanged between nodes
+const (
+	kindJoin       = "join"       // home -> leader: queue this player
+	kindAttach     = "attach"     // -> owner: reattach a returning player
+	kindHost       = "host"       // leader -> owner: start this game
+	kindHosted     = "hosted"     // owner -> home: send this player's moves to me
+	kindClient     = "client"     // home -> owner/leader: a message from the player
+	kindDisconnect = "disconnect" // home -> owner/leader: the player's socket closed
+)
+
+type hostRequest struct {
+	Players []hostPlayer `json:"players"` // first moves first
+	Bot     bool         `json:"bot"`
+	Rating  float64      `json:"rating"` // picks the bot level
+}
+
+type hostPlayer struct {
+	Username string  `json:"username"`
+	Home     string  `json:"home"`
+	Rating   float64 `json:"rating"`
+}
+
+// relayState is what a cluster node knows about its players
+type relayState struct {
+	sync.Mutex
+	conns      map[string]*wsConn // sockets held by this node; relayed writes share their writer
+	routes     map[string]string  // username -> node hosting their game
+	queued     map[string]bool    // players we've asked the leader to queue
+	lastLeader string
+}
+
+// EnableCluster makes s one node of several behind one load balancer,
+// routing matchmaking and games through n. Call it before n.Start and before
+// serving traffic. Without it s is a single node, which is how everything
+// behaved before clustering.
+//
+// Players keep their websocket on whichever node they connected to (their
+// "home"). The leader runs the one matchmaking queue; each game is hosted by
+// one node picked by rendezvous hashing, and replies flow back to the home
+// node as "deliver" envelopes. Private rooms and spectators stay node-local.
+func (s *Server) EnableCluster(n *cluster.Node) {
+	s.node = n
+	s.relay = relayState{
+		conns:      make(map[string]*wsConn),
+		routes:     make(map[string]string),
+		queued:     make(map[string]bool),
+		lastLeader: n.ID,
+	}
+
+	n.Handle(cluster.KindDeliver, func(env cluster.Envelope) {
+		s.relay.Lock()
+		conn := s.relay.conns[env.Username]
+		s.relay.Unlock()
+		if conn != nil {
+			conn.WriteJSON(env.Payload)
+		}
+	})
+
+	n.Handle(kindJoin, func(env cluster.Envelope) {
+		if !n.IsLeader() {
+			// Leadership moved while this was in flight
+			n.Send(n.Leader(), env)
+			return
+		}
+		s.matchmaker.Join(env.Username, s.connFor(env.Home, env.Username))
+	})
+
+	n.Handle(kindAttach, func(env cluster.Envelope) {
+		if !s.Reconnect(env.Username, s.connFor(env.Home, env.Username)) {
+			// The game is over; queue them like any new arrival
+			n.Send(n.Leader(), cluster.Envelope{Kind: kindJoin, Home: env.Home, Username: env.Username})
+		}
+	})
+
+	n.Handle(kindHost, func(env cluster.Envelope) {
+		var req hostRequest
+		if err := json.Unmarshal(env.Payload, &req); err != nil || len(req.Players) == 0 {
+			log.Printf("[CLUSTER] Bad host request from %s: %v", env.From, err)
+			return
+		}
+		if s.Draining() {
+			// Picked before our draining heartbeat arrived; queue them again
+			for _, hp := range req.Players {
+				n.Send(n.Leader(), cluster.Envelope{Kind: kindJoin, Home: hp.Home, Username: hp.Username})
+			}
+			return
+		}
+		var players []*game.Player
+		for _, hp := range req.Players {
+			players = append(players, &game.Player{
+				ID: uuid.New().String(), Username: hp.Username, Conn: s.connFor(hp.Home, hp.Username),
+				IsConnected: true, Rating: hp.Rating,
+			})
+		}
+		if req.Bot {
+			s.startBotGame(players[0], req.Rating)
+		} else if len(players) == 2 {
+			s.StartGameWithOptions(players[0], players[1], DefaultGameOptions())
+		}
+	})
+
+	n.Handle(kindHosted, func(env cluster.Envelope) {
+		s.relay.Lock()
+		s.relay.routes[env.Username] = env.From
+		delete(s.relay.queued, env.Username)
+		s.relay.Unlock()
+	})
+
+	n.Handle(kindClient, func(env cluster.Envelope) {
+		var msg game.WSMessage
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return
+		}
+		s.dispatchClientMessage(env.Username, s.connFor(env.Home, env.Username), msg)
+	})
+
+	n.Handle(kindDisconnect, func(env cluster.Envelope) {
+		s.handleDisconnect(env.Username, s.connFor(env.Home, env.Username))
+	})
+
+	n.OnLeaderChange(s.onLeaderChange)
+}
+
+// onLeaderChange keeps the single queue intact across leader changes: a
+// node that lost leadership hands its waiting players over, and if the old
+// leader died, homes re-queue the players they were waiting on
+func (s *Server) onLeaderChange(leader string) {
+	s.relay.Lock()
+	prev := s.relay.lastLeader
+	s.relay.lastLeader = leader
+	var requeue []string
+	if prev != s.node.ID && !s.node.Alive(prev) {
+		for username := range s.relay.queued {
+			requeue = append(requeue, username)
+		}
+	}
+	s.relay.Unlock()
+
+	if prev == s.node.ID {
+		for _, e := range s.matchmaker.Drain() {
+			s.node.Send(leader, cluster.Envelope{Kind: kindJoin, Home: s.homeOf(e.Player.Conn), Username: e.Player.Username})
+		}
+	}
+	for _, username := range requeue {
+		s.node.Send(leader, cluster.Envelope{Kind: kindJoin, Username: username})
+	}
+}
+
+// nodeID is the node games started here belong to, or "" without a cluster
+func (s *Server) nodeID() string {
+	if s.node == nil {
+		return ""
+	}
+	return s.node.ID
+}
+
+// hostedElsewhere reports whether g is a copy of a game another node runs,
+// as seen through a shared store
+func (s *Server) hostedElsewhere(g *game.Game) bool {
+	return s.node != nil && g.Owner != "" && g.Owner != s.node.ID
+}
+
+// homeOf is the node holding the socket behind conn
+func (s *Server) homeOf(conn game.Conn) string {
+	if rc, ok := conn.(cluster.RemoteConn); ok {
+		return rc.Home
+	}
+	return s.node.ID
+}
+
+// connFor writes straight to the socket when we hold it, otherwise relays
+func (s *Server) connFor(home, username string) game.Conn {
+	if home == s.node.ID {
+		s.relay.Lock()
+		conn := s.relay.conns[username]
+		s.relay.Unlock()
+		if conn != nil {
+			return conn
+		}
+	}
+	return cluster.RemoteConn{Node: s.node, Home: home, Username: username}
+}
+
+func (s *Server) routeOf(username string) string {
+	s.relay.Lock()
+	defer s.relay.Unlock()
+	owner := s.relay.routes[username]
+	if owner != "" && !s.node.Alive(owner) {
+		delete(s.relay.routes, username)
+		return ""
+	}
+	return owner
+}
+
+// joinMatchmaking puts a freshly connected player into their running game
+// or the queue, wherever in the cluster those live
+func (s *Server) joinMatchmaking(username string, conn *wsConn) {
+	if s.node == nil {
+		s.matchmaker.Join(username, conn)
+		return
+	}
+
+	s.relay.Lock()
+	s.relay.conns[username] = conn
+	s.relay.Unlock()
+
+	if owner := s.routeOf(username); owner != "" {
+		s.node.Send(owner, cluster.Envelope{Kind: kindAttach, Username: username})
+		return
+	}
+	s.relay.Lock()
+	s.relay.queued[username] = true
+	s.relay.Unlock()
+	s.node.Send(s.node.Leader(), cluster.Envelope{Kind: kindJoin, Username: username})
+}
+
+// routeClientMessage hands a message from one of our sockets to the node
+// that can act on it: the leader for queue changes, otherwise the game's host
+func (s *Server) routeClientMessage(username string, conn game.Conn, msg game.WSMessage) {
+	if s.node == nil {
+		s.dispatchClientMessage(username, conn, msg)
+		return
+	}
+
+	target := s.routeOf(username)
+	if msg.Type == "leave_queue" {
+		target = s.node.Leader()
+		s.relay.Lock()
+		delete(s.relay.queued, username)
+		s.relay.Unlock()
+	}
+	if target == "" || target == s.node.ID {
+		s.dispatchClientMessage(username, conn, msg)
+		return
+	}
+
+	payload, _ := json.Marshal(msg)
+	if err := s.node.Send(target, cluster.Envelope{Kind: kindClient, Username: username, Payload: payload}); err != nil {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: "server unavailable, try again"})
+	}
+}
+
+// routeDisconnect tells the leader and the game's host that the player's
+// socket on this node closed
+func (s *Server) routeDisconnect(username string, conn game.Conn) {
+	if s.node == nil {
+		s.handleDisconnect(username, conn)
+		return
+	}
+
+	s.relay.Lock()
+	current := s.relay.conns[username] == conn
+	if current {
+		delete(s.relay.conns, username)
+		delete(s.relay.queued, username)
+	}
+	s.relay.Unlock()
+	if !current {
+		return // an older socket; the player already reconnected here
+	}
+
+	targets := map[string]bool{s.node.Leader(): true}
+	if owner := s.routeOf(username); owner != "" {
+		targets[owner] = true
+	}
+	for target := range targets {
+		if target == s.node.ID {
+			s.handleDisconnect(username, conn)
+			continue
+		}
+		s.node.Send(target, cluster.Envelope{Kind: kindDisconnect, Username: username})
+	}
+}
+
+// handOff asks the node chosen to host a new game to start it. It reports
+// false when the game should start here.
+func (s *Server) handOff(players []*game.Player, bot bool, rating float64) bool {
+	if s.node == nil {
+		return false
+	}
+	names := make([]string, len(players))
+	for i, p := range players {
+		names[i] = p.Username
+	}
+	sort.Strings(names)
+	owner := s.node.PickOwner(strings.Join(names, "\x00"))
+	if owner == s.node.ID {
+		return false
+	}
+
+	req := hostRequest{Bot: bot, Rating: rating}
+	for _, p := range players {
+		req.Players = append(req.Players, hostPlayer{Username: p.Username, Home: s.homeOf(p.Conn), Rating: p.Rating})
+	}
+	payload, _ := json.Marshal(req)
+	if err := s.node.Send(owner, cluster.Envelope{Kind: kindHost, Payload: payload}); err != nil {
+		log.Printf("[CLUSTER] Could not hand game to %s, hosting it here: %v", owner, err)
+		return false
+	}
+	log.Printf("[CLUSTER] Game for %s hosted on %s", strings.Join(names, " vs "), owner)
+	return true
+}
+
+// announceHost tells each player's home node that we run their game, so
+// their moves get relayed here
+func (s *Server) announceHost(g *game.Game) {
+	if s.node == nil {
+		return
+	}
+	for _, p := range g.Players {
+		if !p.IsBot {
+			s.node.Send(s.homeOf(p.Conn), cluster.Envelope{Kind: kindHosted, Username: p.Username})
+		}
+	}
+}
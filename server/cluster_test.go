@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fourinrow/cluster"
+	"fourinrow/config"
+	"fourinrow/game"
+
+	"github.com/gorilla/websocket"
+)
+
+// testNode is one server of a test cluster, listening on its own port
+type testNode struct {
+	srv  *Server
+	node *cluster.Node
+	url  string
+}
+
+func startTestNode(t *testing.T, id string, broker cluster.Broker) *testNode {
+	t.Helper()
+	srv := newServer(config.Default(), game.NewMemoryStore(game.MaxGames))
+	node := cluster.NewNode(id, broker)
+	srv.EnableCluster(node)
+	if err := node.Start(); err != nil {
+		t.Fatalf("start node %s: %v", id, err)
+	}
+	t.Cleanup(node.Stop)
+	ts := httptest.NewServer(http.HandlerFunc(srv.WebSocketHandler))
+	t.Cleanup(ts.Close)
+	return &testNode{srv: srv, node: node, url: "ws" + strings.TrimPrefix(ts.URL, "http")}
+}
+
+// testPlayer is a websocket client
+type testPlayer struct {
+	t    *testing.T
+	name string
+	ws   *websocket.Conn
+}
+
+func connectPlayer(t *testing.T, n *testNode, username string) *testPlayer {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(n.url+"/?username="+username, nil)
+	if err != nil {
+		t.Fatalf("%s could not connect: %v", username, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &testPlayer{t: t, name: username, ws: ws}
+}
+
+type testMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// await reads until a message of the given type satisfies ok
+func (p *testPlayer) await(typ string, ok func(json.RawMessage) bool) json.RawMessage {
+	p.t.Helper()
+	p.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg testMessage
+		if err := p.ws.ReadJSON(&msg); err != nil {
+			p.t.Fatalf("%s waiting for %q: %v", p.name, typ, err)
+		}
+		if msg.Type == "error" {
+			p.t.Fatalf("%s got an error: %s", p.name, msg.Payload)
+		}
+		if msg.Type == typ && ok(msg.Payload) {
+			return msg.Payload
+		}
+	}
+}
+
+func (p *testPlayer) move(col int) {
+	p.t.Helper()
+	if err := p.ws.WriteJSON(game.WSMessage{Type: "move", Payload: map[string]int{"column": col}}); err != nil {
+		p.t.Fatalf("%s move: %v", p.name, err)
+	}
+}
+
+// awaitMoves waits for the board after the given number of moves
+func (p *testPlayer) awaitMoves(n int) *game.Game {
+	p.t.Helper()
+	var g *game.Game
+	p.await("update", func(raw json.RawMessage) bool {
+		g = &game.Game{}
+		return json.Unmarshal(raw, g) == nil && len(g.Moves) == n
+	})
+	return g
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClusterRelaysGameBetweenNodes(t *testing.T) {
+	useStubAnalytics()
+	broker := cluster.NewLocalBroker()
+	a := startTestNode(t, "node-a", broker)
+	b := startTestNode(t, "node-b", broker)
+	waitUntil(t, "both nodes follow node-a", func() bool {
+		return a.node.Leader() == "node-a" && b.node.Leader() == "node-a"
+	})
+
+	// alice is queued on the leader; bob's join is relayed there from node-b
+	alice := connectPlayer(t, a, "alice")
+	alice.await("waiting", func(json.RawMessage) bool { return true })
+	bob := connectPlayer(t, b, "bob")
+
+	var aliceStart, bobStart struct {
+		GameID   string `json:"gameId"`
+		PlayerID string `json:"playerId"`
+		Color    int    `json:"color"`
+		Opponent string `json:"opponent"`
+	}
+	json.Unmarshal(alice.await("start", func(json.RawMessage) bool { return true }), &aliceStart)
+	json.Unmarshal(bob.await("start", func(json.RawMessage) bool { return true }), &bobStart)
+	if aliceStart.GameID == "" || aliceStart.GameID != bobStart.GameID {
+		t.Fatalf("alice and bob started games %q and %q", aliceStart.GameID, bobStart.GameID)
+	}
+	if aliceStart.Color != 1 || aliceStart.Opponent != "bob" || bobStart.Opponent != "alice" {
+		t.Fatalf("start messages = %+v and %+v, want alice first against bob", aliceStart, bobStart)
+	}
+
+	// Exactly one node hosts the game, so one player's moves cross the broker
+	host, other := a, b
+	if game.GetGame(b.srv.store, aliceStart.GameID) != nil {
+		host, other = b, a
+	}
+	if game.GetGame(host.srv.store, aliceStart.GameID) == nil || game.GetGame(other.srv.store, aliceStart.GameID) != nil {
+		t.Fatal("the game should live on exactly one node")
+	}
+
+	// alice stacks column 0, bob column 1; alice connects four on move 7
+	var final *game.Game
+	for i := 0; i < 7; i++ {
+		if i%2 == 0 {
+			alice.move(0)
+		} else {
+			bob.move(1)
+		}
+		alice.awaitMoves(i + 1)
+		final = bob.awaitMoves(i + 1)
+	}
+	if final.Status != "finished" || final.Winner != aliceStart.PlayerID || final.EndReason != game.EndConnectFour {
+		t.Fatalf("game ended %s/%s won by %q, want alice (%s) by connect four", final.Status, final.EndReason, final.Winner, aliceStart.PlayerID)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeWait bounds a single write, so a stalled client can't hold up the
+// game (or the lock) that is writing to it
+const writeWait = 10 * time.Second
+
+// wsConn is a websocket with a single writer. gorilla/websocket allows one
+// concurrent writer per connection, but a player's socket is written from
+// their own read loop, their opponent's, clock and forfeit timers, chat and
+// the cluster relay, so every write takes the mutex. Reads still happen on
+// the embedded connection from the one goroutine that owns it.
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	return &wsConn{Conn: conn}
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteJSON(v)
+}
+
+// WriteMessage is WriteJSON for data that is already encoded
+func (c *wsConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteMessage(messageType, data)
+}
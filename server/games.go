@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fourinrow/game"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type liveGamesResponse struct {
+	Games  []game.GameSummary `json:"games"`
+	Total  int                `json:"total"`
+	Limit  int                `json:"limit"`
+	Offset int                `json:"offset"`
+}
+
+// LiveGamesHandler lists games in progress: GET /api/games/live
+//
+// Query parameters:
+//   - limit, offset: paging (newest games first)
+//   - variant: only games of this variant
+//   - mode: "pvp" or "bot"
+//   - player: only games with a player whose name contains this (case-insensitive)
+//   - minRating: only games where some human is rated at least this
+func (s *Server) LiveGamesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, err := parsePage(q.Get("limit"), q.Get("offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minRating := 0.0
+	if v := q.Get("minRating"); v != "" {
+		if minRating, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, "invalid minRating", http.StatusBadRequest)
+			return
+		}
+	}
+	variant, mode := q.Get("variant"), q.Get("mode")
+	player := strings.ToLower(q.Get("player"))
+
+	// Snapshot first: everything below runs without the store lock
+	var matched []game.GameSummary
+	for _, sum := range game.SnapshotLive(s.store) {
+		if variant != "" && sum.Variant != variant {
+			continue
+		}
+		if (mode == "pvp" && sum.IsBotGame) || (mode == "bot" && !sum.IsBotGame) {
+			continue
+		}
+		if player != "" && !hasPlayer(sum, player) {
+			continue
+		}
+		if minRating > 0 && topRating(sum) < minRating {
+			continue
+		}
+		matched = append(matched, sum)
+	}
+
+	resp := liveGamesResponse{Games: []game.GameSummary{}, Total: len(matched), Limit: limit, Offset: offset}
+	if offset < len(matched) {
+		end := offset + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		resp.Games = matched[offset:end]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parsePage reads limit/offset query values, applying defaults and bounds
+func parsePage(limitStr, offsetStr string) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			return 0, 0, errInvalidParam("limit")
+		}
+		limit = min(n, maxPageSize)
+	}
+	if offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return 0, 0, errInvalidParam("offset")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+type errInvalidParam string
+
+func (e errInvalidParam) Error() string { return "invalid " + string(e) }
+
+func hasPlayer(s game.GameSummary, needle string) bool {
+	for _, p := range s.Players {
+		if strings.Contains(strings.ToLower(p.Username), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func topRating(s game.GameSummary) float64 {
+	top := 0.0
+	for _, p := range s.Players {
+		if !p.IsBot && p.Rating > top {
+			top = p.Rating
+		}
+	}
+	return top
+}
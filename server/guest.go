@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"fourinrow/db"
+
+	"github.com/google/uuid"
+)
+
+const (
+	GuestCookieName = "fir_guest"
+	GuestPrefix     = "Guest-"
+	guestCookieTTL  = 365 * 24 * time.Hour
+	// guestMintAttempts bounds the retries when a fresh guest name is taken
+	guestMintAttempts = 5
+)
+
+// loadSessionSecret is the key that signs guest cookies. Without a
+// configured secret we fall back to a random key, which means guest cookies
+// don't survive a restart.
+func loadSessionSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	log.Println("[AUTH] SESSION_SECRET not set, using a random key (guest cookies reset on restart)")
+	return randomKey()
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+type GuestIdentity struct {
+	ID       string `json:"guestId"`
+	Username string `json:"username"`
+}
+
+func guestUsername(id string) string {
+	return GuestPrefix + strings.ReplaceAll(id, "-", "")[:8]
+}
+
+func (s *Server) signGuestID(id string) string {
+	mac := hmac.New(sha256.New, s.sessionSecret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) verifyGuestCookie(value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	expected := s.signGuestID(id)
+	if !hmac.Equal([]byte(expected), []byte(id+"."+sig)) {
+		return "", false
+	}
+	return id, true
+}
+
+// guestFromRequest returns the guest identity carried by a valid signed cookie
+func (s *Server) guestFromRequest(r *http.Request) (*GuestIdentity, bool) {
+	c, err := r.Cookie(GuestCookieName)
+	if err != nil {
+		return nil, false
+	}
+	id, ok := s.verifyGuestCookie(c.Value)
+	if !ok {
+		return nil, false
+	}
+	return &GuestIdentity{ID: id, Username: guestUsername(id)}, true
+}
+
+// ErrNoGuestName means every guest name we tried was already taken
+var ErrNoGuestName = errors.New("could not allocate a guest name, try again")
+
+// newGuest mints a fresh guest identity and the cookie that carries it.
+// Guest names only use part of the ID, so a name another guest already
+// holds is retried with a new ID, up to guestMintAttempts times.
+func (s *Server) newGuest() (*GuestIdentity, *http.Cookie, error) {
+	for i := 0; i < guestMintAttempts; i++ {
+		id := uuid.New().String()
+		guest := &GuestIdentity{ID: id, Username: guestUsername(id)}
+		if err := trackGuest(guest); errors.Is(err, db.ErrGuestNameTaken) {
+			log.Printf("[AUTH] Guest name %s already taken, minting another", guest.Username)
+			continue
+		}
+		cookie := &http.Cookie{
+			Name:     GuestCookieName,
+			Value:    s.signGuestID(id),
+			Path:     "/",
+			Expires:  time.Now().Add(guestCookieTTL),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		return guest, cookie, nil
+	}
+	log.Printf("[AUTH] ⚠️ No free guest name after %d attempts", guestMintAttempts)
+	return nil, nil, ErrNoGuestName
+}
+
+// resolveGuest returns the caller's guest identity, minting one if needed.
+// The returned cookie is nil when the request already carried a valid one.
+func (s *Server) resolveGuest(r *http.Request) (*GuestIdentity, *http.Cookie, error) {
+	if guest, ok := s.guestFromRequest(r); ok {
+		// A cookie whose name is held by another guest is replaced
+		if err := trackGuest(guest); !errors.Is(err, db.ErrGuestNameTaken) {
+			return guest, nil, nil
+		}
+	}
+	return s.newGuest()
+}
+
+// trackGuest records the guest. Only ErrGuestNameTaken is returned; other
+// database errors are logged so guests can still play without a database.
+func trackGuest(g *GuestIdentity) error {
+	if db.Repo == nil {
+		return nil
+	}
+	err := db.Repo.SaveGuest(g.ID, g.Username)
+	if errors.Is(err, db.ErrGuestNameTaken) {
+		return err
+	}
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to save guest %s: %v", g.ID, err)
+	}
+	return nil
+}
+
+func clearGuestCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     GuestCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// GuestHandler hands out (or confirms) the caller's guest identity
+func (s *Server) GuestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	guest, cookie, err := s.resolveGuest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(guest)
+}
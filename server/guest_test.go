@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fourinrow/config"
+	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+func newGuestServer(t *testing.T, repo db.Repository) *Server {
+	t.Helper()
+	prev := db.Repo
+	db.Repo = repo
+	t.Cleanup(func() { db.Repo = prev })
+	cfg := config.Default()
+	cfg.SessionSecret = "test-secret"
+	return newServer(cfg, game.NewMemoryStore(game.MaxGames))
+}
+
+// getGuest calls GuestHandler, sending cookie if it isn't nil
+func getGuest(t *testing.T, s *Server, cookie *http.Cookie) (*httptest.ResponseRecorder, GuestIdentity) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/guest", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.GuestHandler(rec, req)
+	var guest GuestIdentity
+	json.NewDecoder(rec.Body).Decode(&guest)
+	return rec, guest
+}
+
+func guestCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == GuestCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestGuestCookieSigning(t *testing.T) {
+	s := newGuestServer(t, db.NewMemoryRepository())
+	rec, guest := getGuest(t, s, nil)
+	cookie := guestCookie(rec)
+	if cookie == nil || guest.ID == "" || !strings.HasPrefix(guest.Username, GuestPrefix) {
+		t.Fatalf("first visit got %+v with cookie %v", guest, cookie)
+	}
+
+	// A valid cookie brings back the same guest and isn't reissued
+	rec, again := getGuest(t, s, cookie)
+	if again != guest || guestCookie(rec) != nil {
+		t.Errorf("returning guest = %+v (new cookie %v), want %+v", again, guestCookie(rec), guest)
+	}
+
+	id, sig, _ := strings.Cut(cookie.Value, ".")
+	otherID := "0" + id[1:]
+	if otherID == id {
+		otherID = "1" + id[1:]
+	}
+	other := newGuestServer(t, db.NewMemoryRepository())
+	other.sessionSecret = []byte("another-secret")
+	for name, value := range map[string]string{
+		"another guest's id":  otherID + "." + sig,
+		"a changed signature": id + "." + strings.ToUpper(sig),
+		"no signature":        id,
+		"an empty id":         "." + sig,
+	} {
+		if _, ok := s.verifyGuestCookie(value); ok {
+			t.Errorf("cookie with %s verified", name)
+		}
+	}
+	if _, ok := other.verifyGuestCookie(cookie.Value); ok {
+		t.Error("cookie signed with another secret verified")
+	}
+
+	// A tampered cookie gets a fresh identity rather than the claimed one
+	rec, fresh := getGuest(t, s, &http.Cookie{Name: GuestCookieName, Value: id + "." + strings.ToUpper(sig)})
+	if fresh.ID == guest.ID || guestCookie(rec) == nil {
+		t.Errorf("tampered cookie resolved to %+v, want a new guest", fresh)
+	}
+}
+
+// takenNames is a repository where every guest name is already taken
+type takenNames struct{ db.Repository }
+
+func (takenNames) SaveGuest(id, username string) error { return db.ErrGuestNameTaken }
+
+func TestGuestNamesExhausted(t *testing.T) {
+	s := newGuestServer(t, takenNames{db.NewMemoryRepository()})
+	rec, _ := getGuest(t, s, nil)
+	if rec.Code != http.StatusServiceUnavailable || guestCookie(rec) != nil {
+		t.Fatalf("status %d with cookie %v, want 503 and no identity", rec.Code, guestCookie(rec))
+	}
+}
+
+func createAccount(t *testing.T, s *Server, username string, cookie *http.Cookie) (*httptest.ResponseRecorder, createAccountResponse) {
+	t.Helper()
+	body, _ := json.Marshal(createAccountRequest{Username: username, Password: "correct horse"})
+	req := httptest.NewRequest(http.MethodPost, "/api/accounts", bytes.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.AccountsHandler(rec, req)
+	var resp createAccountResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return rec, resp
+}
+
+func TestAccountMergesGuest(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	s := newGuestServer(t, repo)
+	rec, guest := getGuest(t, s, nil)
+	cookie := guestCookie(rec)
+
+	// The guest beats a registered player
+	g := storetest.NewGame(guest.Username, "merge_opp", time.Now().Add(-time.Minute))
+	g.Status, g.Winner = "finished", g.Players[guest.Username].ID
+	changes, err := repo.SaveGame(g)
+	if err != nil {
+		t.Fatalf("SaveGame: %v", err)
+	}
+
+	rec, resp := createAccount(t, s, "merge_user", cookie)
+	if rec.Code != http.StatusCreated || resp.Username != "merge_user" || resp.MergedGuest != guest.ID {
+		t.Fatalf("create account = %d %+v, want 201 merging %s", rec.Code, resp, guest.ID)
+	}
+	if c := guestCookie(rec); c == nil || c.MaxAge >= 0 {
+		t.Errorf("guest cookie after upgrade = %v, want it cleared", c)
+	}
+
+	sg, err := repo.GetGame(g.ID)
+	if err != nil || sg.Player1 != "merge_user" || sg.Winner != "merge_user" {
+		t.Errorf("merged game = %+v, %v, want it won by merge_user", sg, err)
+	}
+	if got, _ := repo.GetRating("merge_user"); got != changes[guest.Username].After {
+		t.Errorf("merged rating = %v, want the guest's %v", got, changes[guest.Username].After)
+	}
+	stats, err := repo.GetPlayerStats("merge_user")
+	if err != nil || stats.Games != 1 || stats.Wins != 1 {
+		t.Errorf("merged stats = %+v, %v, want one win", stats, err)
+	}
+	if stats, err := repo.GetPlayerStats(guest.Username); err == nil && stats.Games != 0 {
+		t.Errorf("guest still has %d games after the merge", stats.Games)
+	}
+
+	// A guest with no games upgrades without reporting a merge
+	rec, _ = getGuest(t, s, nil)
+	if rec, resp := createAccount(t, s, "fresh_user", guestCookie(rec)); rec.Code != http.StatusCreated || resp.MergedGuest != "" {
+		t.Errorf("upgrading a guest without games = %d %+v, want no merge reported", rec.Code, resp)
+	}
+}
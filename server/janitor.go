@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"fourinrow/game"
+)
+
+const JanitorInterval = time.Minute
+
+// RunStoreJanitor periodically evicts finished games and reaps abandoned ones
+func (s *Server) RunStoreJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, g := range game.Sweep(s.store, now, s.nodeID()) {
+			log.Printf("[JANITOR] Reaped abandoned game %s", g.ID)
+			g.Lock()
+			g.Status = "abandoned"
+			stopClock(g)
+			for _, p := range g.Players {
+				if p.DisconnectTimer != nil {
+					p.DisconnectTimer.Stop()
+				}
+			}
+			s.spectators.Close(g.ID)
+			g.Unlock()
+		}
+	}
+}
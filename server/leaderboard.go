@@ -1,18 +1,166 @@
 package server
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"fourinrow/db"
 )
 
-func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+const maxLeaderboardSize = 100
+
+// Win rates mean little over a handful of games
+const defaultWinRateMinGames = 10
+
+type leaderboardResponse struct {
+	Entries    []db.LeaderboardEntry `json:"entries"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+	// The "me" player's own entry, null if they don't qualify
+	Me *db.LeaderboardEntry `json:"me,omitempty"`
+}
+
+// LeaderboardHandler ranks players: GET /leaderboard
+//
+// Query parameters:
+//   - period: daily, weekly, monthly or all-time (default); windows start at
+//     midnight UTC, on Monday and on the 1st
+//   - mode: pvp (default), bot or all
+//   - variant: only games of this variant
+//   - metric: rating (default), wins or win-rate
+//   - minGames: games a player needs in the window (default 1, 10 for win-rate)
+//   - limit, cursor: paging; pass back nextCursor for the following page
+//   - me: also return this player's position
+//
+// Results are cached for LEADERBOARD_TTL, so a finished game can take that
+// long to show up.
+func (s *Server) LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 	if db.Repo == nil {
 		http.Error(w, "DB unavailable", 503)
 		return
 	}
 
-	data, _ := db.Repo.GetLeaderboard()
-	json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+	now := time.Now()
+	q, err := parseLeaderboardQuery(r, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.leaderboards.leaderboard(q, now)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load leaderboard: %v", err)
+		http.Error(w, "failed to load leaderboard", http.StatusInternalServerError)
+		return
+	}
+	resp := leaderboardResponse{Entries: entries}
+	if resp.Entries == nil {
+		resp.Entries = []db.LeaderboardEntry{}
+	}
+	if len(entries) == q.Limit {
+		resp.NextCursor = encodeCursor(q.Offset + q.Limit)
+	}
+
+	if me := r.URL.Query().Get("me"); me != "" {
+		if resp.Me, err = s.leaderboards.position(q, me, now); err != nil {
+			log.Printf("[DB ERROR] Failed to find %s on the leaderboard: %v", me, err)
+			http.Error(w, "failed to load leaderboard", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseLeaderboardQuery(r *http.Request, now time.Time) (db.LeaderboardQuery, error) {
+	v := r.URL.Query()
+	q := db.LeaderboardQuery{Mode: db.ModePvP, Metric: db.MetricRating, Limit: db.LeaderboardSize, Variant: v.Get("variant")}
+
+	var ok bool
+	if q.Since, ok = periodStart(v.Get("period"), now); !ok {
+		return q, errInvalidParam("period")
+	}
+	switch m := v.Get("mode"); m {
+	case "":
+	case db.ModePvP, db.ModeBot, db.ModeAll:
+		q.Mode = m
+	default:
+		return q, errInvalidParam("mode")
+	}
+	if q.Variant != "" && !Variants[q.Variant] {
+		return q, errInvalidParam("variant")
+	}
+	switch m := v.Get("metric"); m {
+	case "":
+	case db.MetricRating, db.MetricWins, db.MetricWinRate:
+		q.Metric = m
+	default:
+		return q, errInvalidParam("metric")
+	}
+
+	q.MinGames = 1
+	if q.Metric == db.MetricWinRate {
+		q.MinGames = defaultWinRateMinGames
+	}
+	if s := v.Get("minGames"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, errInvalidParam("minGames")
+		}
+		q.MinGames = n
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, errInvalidParam("limit")
+		}
+		q.Limit = min(n, maxLeaderboardSize)
+	}
+	if s := v.Get("cursor"); s != "" {
+		n, err := decodeCursor(s)
+		if err != nil {
+			return q, errInvalidParam("cursor")
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+// periodStart is when the named window began, zero for all time
+func periodStart(period string, now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "", "all-time":
+		return time.Time{}, true
+	case "daily":
+		return today, true
+	case "weekly":
+		// Weeks start on Monday
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7), true
+	case "monthly":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// Cursors are opaque to clients so paging can change without breaking them
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(s string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < 0 {
+		return 0, errInvalidParam("cursor")
+	}
+	return n, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"fourinrow/db"
+)
+
+// Queries differ by period, mode, variant, metric and page, so a busy
+// leaderboard needs only a handful of entries; the cap guards against
+// clients walking every page
+const maxCachedLeaderboards = 1000
+
+// ttlCache keeps values for ttl; zero turns it off. Concurrent misses on the
+// same key each load it; the last one wins.
+type ttlCache[K comparable, V any] struct {
+	ttl          time.Duration
+	mu           sync.Mutex
+	entries      map[K]ttlEntry[V]
+	hits, misses int64
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// get returns the cached value for key, calling load on a miss or after expiry
+func (c *ttlCache[K, V]) get(key K, now time.Time, load func() (V, error)) (V, error) {
+	ttl := c.ttl
+	if ttl <= 0 {
+		return load()
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+		c.hits++
+		c.mu.Unlock()
+		return e.value, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxCachedLeaderboards {
+		c.prune(now)
+	}
+	c.entries[key] = ttlEntry[V]{value: v, expires: now.Add(ttl)}
+	return v, nil
+}
+
+// prune drops expired entries, or everything if that doesn't make room
+func (c *ttlCache[K, V]) prune(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if c.entries == nil || len(c.entries) >= maxCachedLeaderboards {
+		c.entries = make(map[K]ttlEntry[V])
+	}
+}
+
+func (c *ttlCache[K, V]) stats() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]int64{"entries": int64(len(c.entries)), "hits": c.hits, "misses": c.misses}
+}
+
+// positionKey is one player's place on a leaderboard, whatever the page
+type positionKey struct {
+	q        db.LeaderboardQuery
+	username string
+}
+
+// leaderboardCache serves leaderboard pages and positions from memory
+type leaderboardCache struct {
+	pages     ttlCache[db.LeaderboardQuery, []db.LeaderboardEntry]
+	positions ttlCache[positionKey, *db.LeaderboardEntry]
+}
+
+func newLeaderboardCache(ttl time.Duration) *leaderboardCache {
+	c := &leaderboardCache{}
+	c.pages.ttl, c.positions.ttl = ttl, ttl
+	return c
+}
+
+// leaderboard is db.Repo.GetLeaderboard(q) at most the cache's TTL old
+func (c *leaderboardCache) leaderboard(q db.LeaderboardQuery, now time.Time) ([]db.LeaderboardEntry, error) {
+	return c.pages.get(q, now, func() ([]db.LeaderboardEntry, error) {
+		return db.Repo.GetLeaderboard(q)
+	})
+}
+
+// position is db.Repo.GetLeaderboardPosition at most the cache's TTL old
+func (c *leaderboardCache) position(q db.LeaderboardQuery, username string, now time.Time) (*db.LeaderboardEntry, error) {
+	q.Limit, q.Offset = 0, 0
+	return c.positions.get(positionKey{q, username}, now, func() (*db.LeaderboardEntry, error) {
+		return db.Repo.GetLeaderboardPosition(q, username)
+	})
+}
@@ -1,49 +1,71 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"fourinrow/analytics"
+	"fourinrow/cluster"
 	"fourinrow/game"
 	"fourinrow/game/bot"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 )
 
 type Matchmaker struct {
-	mu            sync.Mutex
-	pendingPlayer *game.Player
-	timer         *time.Timer
+	srv   *Server
+	mu    sync.Mutex
+	queue MatchQueue
+
+	// How long a player waits for a human before getting a bot
+	timeout time.Duration
+	// How long the rating search window takes to widen fully
+	widenTime time.Duration
+	// Pause before the bot replies in the games this matchmaker starts
+	botThinkTime time.Duration
+
+	// Injected so pairing can be driven by a fake clock and fixed ratings
+	now      func() time.Time
+	ratingOf func(username string) float64
 }
 
-var GlobalMatchmaker = &Matchmaker{}
+// QueueUpdateInterval is how often waiting players are re-paired, told
+// where they stand and, once the timeout has passed, given a bot
+const QueueUpdateInterval = time.Second
 
-const MatchmakingTimeout = 10 * time.Second
+// newMatchmaker creates srv's matchmaker. runQueueUpdates drives it.
+func newMatchmaker(srv *Server, now func() time.Time, ratingOf func(string) float64) *Matchmaker {
+	cfg := srv.cfg
+	return &Matchmaker{
+		srv:          srv,
+		timeout:      cfg.MatchmakingTimeout.Duration,
+		widenTime:    cfg.SearchWindowTime.Duration,
+		botThinkTime: cfg.BotThinkTime.Duration,
+		now:          now,
+		ratingOf:     ratingOf,
+	}
+}
 
-func (m *Matchmaker) Join(username string, conn *websocket.Conn) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// Join queues the player, or pairs them straight away. Socket writes and
+// game starts happen after m.mu is released, so a slow client can't hold
+// up everyone else joining the queue.
+func (m *Matchmaker) Join(username string, conn game.Conn) {
+	// Look the rating up before taking the lock; it may hit the DB
+	rating := m.ratingOf(username)
 
 	log.Printf("[MATCHMAKER] Player joined: %s", username)
 
-	// 1. Reconnection Logic
-	if activeGame := game.Store.FindGameByPlayerName(username); activeGame != nil {
-		log.Printf("[MATCHMAKER] Reconnecting player %s to game %s", username, activeGame.ID)
-		player := activeGame.Players[username]
-		if player.DisconnectTimer != nil {
-			player.DisconnectTimer.Stop()
-			player.DisconnectTimer = nil
+	if m.srv.Draining() {
+		if !m.srv.Reconnect(username, conn) {
+			conn.WriteJSON(game.WSMessage{Type: "server_restarting", Payload: ErrServerRestarting.Error()})
 		}
-		player.Conn = conn
-		player.IsConnected = true
-		
-		conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{
-			"gameId": activeGame.ID, "color": player.Color, "playerId": player.ID, "opponent": "Opponent", 
-		}})
-		conn.WriteJSON(game.WSMessage{Type: "update", Payload: activeGame})
+		return
+	}
+
+	// 1. Reconnection Logic
+	if m.srv.Reconnect(username, conn) {
 		return
 	}
 
@@ -52,64 +74,247 @@ func (m *Matchmaker) Join(username string, conn *websocket.Conn) {
 		Username:    username,
 		Conn:        conn,
 		IsConnected: true,
+		Rating:      rating,
 	}
 
+	m.mu.Lock()
 	// 2. Prevent Self-Matching (React Strict Mode Fix)
-	if m.pendingPlayer != nil && m.pendingPlayer.Username == username {
-		log.Printf("[MATCHMAKER] Player %s rejoined (replacing pending connection)", username)
-		if m.timer != nil { m.timer.Stop() }
-		m.pendingPlayer = player
-		
-		conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Looking for opponent... (10s)"})
-		m.timer = time.AfterFunc(MatchmakingTimeout, func() {
-			m.mu.Lock()
-			defer m.mu.Unlock()
-			if m.pendingPlayer == player {
-				log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", player.Username)
-				m.pendingPlayer = nil 
-				m.StartBotGame(player)
-			}
-		})
+	// The same user joining twice replaces their queued connection but keeps their place.
+	if existing := m.queue.Find(username); existing != nil {
+		log.Printf("[MATCHMAKER] Player %s rejoined (replacing queued connection)", username)
+		existing.Player = player
+		msgs := m.queueStatus(existing)
+		m.mu.Unlock()
+		writeAll(conn, msgs)
 		return
 	}
 
-	// 3. PvP Match found
-	if m.pendingPlayer != nil {
-		log.Printf("[MATCHMAKER] PvP Match found: %s vs %s", m.pendingPlayer.Username, player.Username)
-		if m.timer != nil { m.timer.Stop() }
-		opponent := m.pendingPlayer
-		m.pendingPlayer = nil 
-		m.StartGame(opponent, player)
+	// 3. PvP Match found (closest rating inside the search window)
+	entry := &QueueEntry{Player: player, Rating: rating, JoinedAt: m.now()}
+	if opponent := FindOpponent(&m.queue, entry, m.now(), m.widenTime); opponent != nil {
+		m.queue.Remove(opponent)
+		m.mu.Unlock()
+		log.Printf("[MATCHMAKER] PvP Match found: %s (%.0f) vs %s (%.0f)",
+			opponent.Player.Username, opponent.Rating, player.Username, rating)
+		m.StartGame(opponent.Player, player)
 		return
 	}
 
 	// 4. Wait for opponent
-	log.Printf("[MATCHMAKER] Player %s waiting for opponent...", username)
-	m.pendingPlayer = player
-	conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Looking for opponent... (10s)"})
-
-	m.timer = time.AfterFunc(MatchmakingTimeout, func() {
-		m.mu.Lock()
-		defer m.mu.Unlock()
-		if m.pendingPlayer == player {
-			log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", player.Username)
-			m.pendingPlayer = nil 
-			m.StartBotGame(player)
+	log.Printf("[MATCHMAKER] Player %s (%.0f) waiting for opponent...", username, rating)
+	msgs := m.enqueue(entry)
+	m.mu.Unlock()
+	writeAll(conn, msgs)
+}
+
+func writeAll(conn game.Conn, msgs []game.WSMessage) {
+	for _, msg := range msgs {
+		conn.WriteJSON(msg)
+	}
+}
+
+// Reconnect puts a returning player back into their active game, if any
+func (s *Server) Reconnect(username string, conn game.Conn) bool {
+	activeGame := game.FindGameByPlayerName(s.store, username)
+	if activeGame == nil {
+		return false
+	}
+
+	if s.hostedElsewhere(activeGame) {
+		if !s.node.Alive(activeGame.Owner) {
+			log.Printf("[MATCHMAKER] Game %s was hosted on %s, which is gone", activeGame.ID, activeGame.Owner)
+			return false
 		}
-	})
+		s.node.Send(activeGame.Owner, cluster.Envelope{Kind: kindAttach, Home: s.homeOf(conn), Username: username})
+		return true
+	}
+
+	activeGame.Lock()
+	defer activeGame.Unlock()
+	if activeGame.Status != "playing" {
+		return false // finished while we were looking it up
+	}
+
+	log.Printf("[MATCHMAKER] Reconnecting player %s to game %s", username, activeGame.ID)
+	player := activeGame.Players[username]
+	if player.DisconnectTimer != nil {
+		player.DisconnectTimer.Stop()
+		player.DisconnectTimer = nil
+	}
+	player.Conn = conn
+	player.IsConnected = true
+	if s.node != nil {
+		s.node.Send(s.homeOf(conn), cluster.Envelope{Kind: kindHosted, Username: username})
+	}
+
+	conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{
+		"gameId": activeGame.ID, "color": player.Color, "playerId": player.ID, "opponent": "Opponent",
+	}})
+	conn.WriteJSON(game.WSMessage{Type: "update", Payload: activeGame})
+	return true
+}
+
+// enqueue adds the entry to the queue and returns its first status update.
+// tick gives it a bot once it has waited out the timeout. Callers hold m.mu.
+func (m *Matchmaker) enqueue(entry *QueueEntry) []game.WSMessage {
+	m.queue.Push(entry)
+	return m.queueStatus(entry)
+}
+
+// Leave takes the player out of the queue, e.g. on "leave_queue" or disconnect.
+// Only the connection currently holding the queue slot can remove it, so a
+// stale socket closing after a rejoin doesn't cancel the new one.
+// It reports whether the player was actually waiting.
+func (m *Matchmaker) Leave(username string, conn game.Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.queue.Find(username)
+	if entry == nil || entry.Player.Conn != conn {
+		return false
+	}
+	m.queue.Remove(entry)
+	log.Printf("[MATCHMAKER] Player %s left the queue", username)
+	return true
+}
+
+// Drain empties the queue and returns who was waiting, e.g. to hand them to
+// a new cluster leader
+func (m *Matchmaker) Drain() []*QueueEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var drained []*QueueEntry
+	for e := m.queue.PopFront(); e != nil; e = m.queue.PopFront() {
+		drained = append(drained, e)
+	}
+	return drained
+}
+
+// queueStatus is what a waiting player is told about their place. Callers
+// hold m.mu.
+func (m *Matchmaker) queueStatus(e *QueueEntry) []game.WSMessage {
+	remaining := m.timeout - e.Waited(m.now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	secs := int(remaining.Round(time.Second).Seconds())
+	return []game.WSMessage{
+		{Type: "waiting", Payload: fmt.Sprintf("Looking for opponent... (%ds)", secs)},
+		{Type: "queue_position", Payload: map[string]interface{}{
+			"position": m.queue.Position(e.Player.Username), "queueSize": m.queue.Len(), "secondsLeft": secs,
+			"searchWindow": int(SearchWindow(e.Waited(m.now()), m.widenTime)),
+		}},
+	}
+}
+
+// runQueueUpdates drives the queue every interval
+func (m *Matchmaker) runQueueUpdates(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.step()
+	}
+}
+
+// step runs tick, then starts the games and sends the queue updates it
+// returns. The sockets serialize their own writes; doing this after
+// unlocking keeps one slow client from holding up everyone joining the
+// queue. It returns the updates sent.
+func (m *Matchmaker) step() []queueUpdate {
+	m.mu.Lock()
+	starts, updates := m.tick()
+	m.mu.Unlock()
+
+	for _, st := range starts {
+		if st.p2 == nil {
+			m.StartBotGame(st.p1, st.rating)
+		} else {
+			m.StartGame(st.p1, st.p2)
+		}
+	}
+	for _, u := range updates {
+		writeAll(u.conn, u.msgs)
+	}
+	return updates
+}
+
+// queueUpdate is a status message for one waiting player
+type queueUpdate struct {
+	conn game.Conn
+	msgs []game.WSMessage
+}
+
+// matchStart is a game tick has taken out of the queue. p2 is nil for a bot
+// game against p1's rating.
+type matchStart struct {
+	p1, p2 *game.Player
+	rating float64
+}
+
+// tick pairs players whose search windows have widened enough, gives a bot
+// to everyone who has waited out the timeout as of m.now, and returns the
+// games to start and the status updates for those still waiting. Callers
+// hold m.mu.
+func (m *Matchmaker) tick() ([]matchStart, []queueUpdate) {
+	now := m.now()
+	var starts []matchStart
+	for _, pair := range PairWaiting(&m.queue, now, m.widenTime) {
+		log.Printf("[MATCHMAKER] PvP Match found: %s (%.0f) vs %s (%.0f)",
+			pair[0].Player.Username, pair[0].Rating, pair[1].Player.Username, pair[1].Rating)
+		starts = append(starts, matchStart{p1: pair[0].Player, p2: pair[1].Player})
+	}
+	// Entries are oldest first, so the timed-out ones are at the front
+	for e := m.queue.Entries(); len(e) > 0 && e[0].Waited(now) >= m.timeout; e = m.queue.Entries() {
+		m.queue.Remove(e[0])
+		log.Printf("[MATCHMAKER] Timeout reached for %s. Starting Bot Game.", e[0].Player.Username)
+		starts = append(starts, matchStart{p1: e[0].Player, rating: e[0].Rating})
+	}
+	var updates []queueUpdate
+	for _, e := range m.queue.Entries() {
+		updates = append(updates, queueUpdate{e.Player.Conn, m.queueStatus(e)})
+	}
+	return starts, updates
 }
 
 func (m *Matchmaker) StartGame(p1, p2 *game.Player) {
+	if m.srv.handOff([]*game.Player{p1, p2}, false, 0) {
+		return
+	}
+	m.srv.StartGameWithOptions(p1, p2, DefaultGameOptions())
+}
+
+// StartGameWithOptions starts a PvP game. p1 takes color 1 and moves first.
+func (s *Server) StartGameWithOptions(p1, p2 *game.Player, opts GameOptions) *game.Game {
 	gameID := uuid.New().String()
 	newGame := &game.Game{
 		ID: gameID, Players: make(map[string]*game.Player),
 		Status: "playing", CurrentTurn: p1.ID, CreatedAt: time.Now(),
+		Variant: opts.Variant, Series: opts.Series, Owner: s.nodeID(),
 	}
 	p1.Color = 1; p1.GameID = gameID
 	p2.Color = 2; p2.GameID = gameID
 	newGame.Players[p1.Username] = p1
 	newGame.Players[p2.Username] = p2
-	game.Store.AddGame(newGame)
+	// Moves can't arrive before both players have their start message
+	newGame.Lock()
+	defer newGame.Unlock()
+	if err := game.Register(s.store, newGame); err != nil {
+		log.Printf("[MATCHMAKER] Could not start game for %s and %s: %v", p1.Username, p2.Username, err)
+		p1.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		p2.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		return nil
+	}
+	s.announceHost(newGame)
+	if opts.Series != nil {
+		opts.Series.GameIDs = append(opts.Series.GameIDs, gameID)
+	}
+	if opts.ClockInitial > 0 {
+		newGame.Clock = game.NewClock(opts.ClockInitial, opts.ClockIncrement, p1.ID, p2.ID)
+		newGame.Clock.Start(newGame.CreatedAt)
+		s.armClock(newGame)
+	}
 
 	// Send Start Signal
 	p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": p2.Username}})
@@ -121,26 +326,43 @@ func (m *Matchmaker) StartGame(p1, p2 *game.Player) {
 	// -------------------------------------
 
 	analytics.Producer.Emit(analytics.GameEvent{Type: "game_started", GameID: gameID, Payload: "PvP"})
+	return newGame
+}
+
+// StartBotGame pits p1 against a bot tuned to their rating
+func (m *Matchmaker) StartBotGame(p1 *game.Player, rating float64) {
+	if m.srv.handOff([]*game.Player{p1}, true, rating) {
+		return
+	}
+	m.srv.startBotGame(p1, rating)
 }
 
-func (m *Matchmaker) StartBotGame(p1 *game.Player) {
+func (s *Server) startBotGame(p1 *game.Player, rating float64) {
 	gameID := uuid.New().String()
-	botPlayer := &game.Player{ID: "cpu", Username: "Bot 🤖", Color: 2, IsBot: true, IsConnected: true, GameID: gameID}
+	botPlayer := &game.Player{ID: "cpu", Username: game.BotUsername, Color: 2, IsBot: true, IsConnected: true, GameID: gameID}
 
 	newGame := &game.Game{
 		ID: gameID, Players: make(map[string]*game.Player),
 		Status: "playing", CurrentTurn: p1.ID, CreatedAt: time.Now(),
+		BotLevel: int(bot.LevelForRating(rating)), Owner: s.nodeID(),
 	}
 	p1.Color = 1; p1.GameID = gameID
 	newGame.Players[p1.Username] = p1
 	newGame.Players["cpu"] = botPlayer 
+	newGame.Lock()
+	defer newGame.Unlock()
 
-	game.Store.AddGame(newGame)
+	if err := game.Register(s.store, newGame); err != nil {
+		log.Printf("[MATCHMAKER] Could not start bot game for %s: %v", p1.Username, err)
+		p1.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		return
+	}
+	s.announceHost(newGame)
 	
 	log.Printf("[MATCHMAKER] Sending start message to %s for Game %s", p1.Username, gameID)
 	
 	// Send Start Signal
-	err := p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": "Bot 🤖"}})
+	err := p1.Conn.WriteJSON(game.WSMessage{Type: "start", Payload: map[string]interface{}{"gameId": gameID, "color": 1, "playerId": p1.ID, "opponent": game.BotUsername}})
 	if err != nil {
 		log.Printf("[ERROR] Failed to send start message: %v", err)
 	}
@@ -152,32 +374,69 @@ func (m *Matchmaker) StartBotGame(p1 *game.Player) {
     analytics.Producer.Emit(analytics.GameEvent{Type: "game_started", GameID: gameID, Payload: "PvE"})
 }
 
-// HandleMove processes the move synchronously
-func HandleMove(g *game.Game, playerUsername string, col int) {
+// HandleMove applies a player's move and, in a bot game, schedules the
+// reply. Callers hold g's lock.
+func (m *Matchmaker) HandleMove(g *game.Game, playerUsername string, col int) {
     player, ok := g.Players[playerUsername]
 	if !ok { return }
+
+    // 0. Out of time? The move doesn't count
+    if g.Clock != nil && g.Status == "playing" && g.CurrentTurn == player.ID &&
+        g.Clock.Left(player.ID, g.CurrentTurn, time.Now()) <= 0 {
+        m.srv.flagPlayer(g, player.ID)
+        return
+    }
     
     // 1. Human Move
     if err := game.ApplyMove(g, player.ID, col); err != nil {
         player.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
         return
     }
-    BroadcastState(g)
-    if g.Status == "finished" { HandleGameOver(g); return }
+    if g.Clock != nil {
+        g.Clock.Punch(player.ID, time.Now())
+        m.srv.armClock(g)
+    }
+    if g.Status == "finished" { m.srv.HandleGameOver(g); return }
+    game.Save(m.srv.store, g)
+    m.srv.BroadcastState(g)
 
-    // 2. Bot Move (Synchronous)
+    // 2. Bot Move, played after the think time without holding the lock
     if g.CurrentTurn == "cpu" {
-        time.Sleep(500 * time.Millisecond) // Think time
-
-        botCol, err := bot.GetBestMove(g, 2)
-        if err != nil {
-            botCol = 0 // Fallback
-        }
-        
-        game.ApplyMove(g, "cpu", botCol)
-        BroadcastState(g)
-        if g.Status == "finished" {
-            HandleGameOver(g)
-        }
+        m.scheduleBotMove(g)
     }
-}
\ No newline at end of file
+}
+
+// scheduleBotMove plays the bot's reply after the think time. Callers hold
+// g's lock. Neither the think time nor the search holds it, so chat,
+// spectators and timers aren't held up while the bot thinks.
+func (m *Matchmaker) scheduleBotMove(g *game.Game) {
+	time.AfterFunc(m.botThinkTime, func() {
+		g.Lock()
+		if g.Status != "playing" || g.CurrentTurn != "cpu" {
+			g.Unlock()
+			return
+		}
+		// The search only reads the board, so it works on a copy
+		view := &game.Game{Board: g.Board}
+		level, ply := bot.Level(g.BotLevel), len(g.Moves)
+		g.Unlock()
+
+		botCol, err := bot.GetMove(view, 2, level)
+		if err != nil {
+			botCol = 0 // Fallback
+		}
+
+		g.Lock()
+		defer g.Unlock()
+		if g.Status != "playing" || g.CurrentTurn != "cpu" || len(g.Moves) != ply {
+			return
+		}
+		game.ApplyMove(g, "cpu", botCol)
+		if g.Status == "finished" {
+			m.srv.HandleGameOver(g)
+			return
+		}
+		game.Save(m.srv.store, g)
+		m.srv.BroadcastState(g)
+	})
+}
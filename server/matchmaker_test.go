@@ -0,0 +1,277 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"fourinrow/analytics"
+	"fourinrow/config"
+	"fourinrow/game"
+)
+
+// recordConn is a game.Conn that keeps everything written to it
+type recordConn struct {
+	mu   sync.Mutex
+	msgs []game.WSMessage
+}
+
+func (c *recordConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg, ok := v.(game.WSMessage); ok {
+		c.msgs = append(c.msgs, msg)
+	}
+	return nil
+}
+
+func (c *recordConn) Close() error { return nil }
+
+// started returns the payload of the first "start" message, or nil
+func (c *recordConn) started() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msg := range c.msgs {
+		if msg.Type == "start" {
+			return msg.Payload.(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+// fakeClock is a clock the test moves by hand
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// useStubAnalytics lets games start and finish without Kafka
+func useStubAnalytics() {
+	if analytics.Producer == nil {
+		analytics.Producer = analytics.NewStubProducer()
+	}
+}
+
+func newTestMatchmaker(t *testing.T, ratings map[string]float64) (*Matchmaker, *fakeClock) {
+	t.Helper()
+	useStubAnalytics()
+	cfg := config.Default()
+	cfg.MatchmakingTimeout = config.Duration{Duration: 10 * time.Second}
+	cfg.SearchWindowTime = config.Duration{Duration: 30 * time.Second}
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	srv := newServer(cfg, game.NewMemoryStore(game.MaxGames))
+	srv.matchmaker = newMatchmaker(srv, clock.now, func(username string) float64 { return ratings[username] })
+	return srv.matchmaker, clock
+}
+
+func entry(name string, rating float64, joined time.Time) *QueueEntry {
+	return &QueueEntry{Player: &game.Player{Username: name}, Rating: rating, JoinedAt: joined}
+}
+
+func TestSearchWindow(t *testing.T) {
+	widen := 30 * time.Second
+	for _, tc := range []struct {
+		waited time.Duration
+		want   float64
+	}{
+		{-time.Second, MinSearchWindow},
+		{0, MinSearchWindow},
+		{15 * time.Second, (MinSearchWindow + MaxSearchWindow) / 2},
+		{30 * time.Second, MaxSearchWindow},
+		{time.Minute, MaxSearchWindow},
+	} {
+		if got := SearchWindow(tc.waited, widen); got != tc.want {
+			t.Errorf("SearchWindow(%v) = %v, want %v", tc.waited, got, tc.want)
+		}
+	}
+	if got := SearchWindow(15*time.Second, 15*time.Second); got != MaxSearchWindow {
+		t.Errorf("a shorter widen time should open the window sooner, got %v", got)
+	}
+}
+
+func TestFindOpponent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	widen := 30 * time.Second
+	var q MatchQueue
+	far := entry("far", 1300, now)
+	older := entry("older", 1220, now.Add(-2*time.Second))
+	newer := entry("newer", 1180, now.Add(-time.Second))
+	q.Push(older)
+	q.Push(newer)
+	q.Push(far)
+
+	me := entry("me", 1200, now)
+	if got := FindOpponent(&q, me, now, widen); got != older {
+		t.Fatalf("FindOpponent = %v, want the longer-waiting of the two closest", got)
+	}
+	q.Remove(older)
+	q.Remove(newer)
+	if got := FindOpponent(&q, me, now, widen); got != nil {
+		t.Fatalf("FindOpponent = %s, want nobody inside a fresh window", got.Player.Username)
+	}
+	// 100 points apart needs a window of 100: 50 + frac*350, reached after 30s*50/350 ≈ 4.3s
+	if got := FindOpponent(&q, me, now.Add(5*time.Second), widen); got != far {
+		t.Fatalf("FindOpponent after far's window widened = %v, want far", got)
+	}
+	if got := FindOpponent(&q, entry("far", 1300, now), now, widen); got != nil {
+		t.Fatal("a player was paired with themselves")
+	}
+}
+
+func TestPairWaiting(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	widen := 30 * time.Second
+	var q MatchQueue
+	a := entry("a", 1000, now)
+	b := entry("b", 1500, now)
+	c := entry("c", 1040, now)
+	d := entry("d", 1700, now)
+	for _, e := range []*QueueEntry{a, b, c, d} {
+		q.Push(e)
+	}
+
+	pairs := PairWaiting(&q, now, widen)
+	if len(pairs) != 1 || pairs[0] != [2]*QueueEntry{a, c} {
+		t.Fatalf("pairs = %v, want only a with c", pairs)
+	}
+	if q.Len() != 2 || q.Position("b") != 1 || q.Position("d") != 2 {
+		t.Fatalf("queue after pairing = %v, want b then d", q.Entries())
+	}
+	if pairs := PairWaiting(&q, now.Add(10*time.Second), widen); len(pairs) != 0 {
+		t.Fatalf("200 points apart paired after 10s: %v", pairs)
+	}
+	if pairs := PairWaiting(&q, now.Add(widen), widen); len(pairs) != 1 || q.Len() != 0 {
+		t.Fatalf("pairs once fully widened = %v, queue %d, want b with d", pairs, q.Len())
+	}
+}
+
+func TestMatchmakerPairsAsWindowWidens(t *testing.T) {
+	m, clock := newTestMatchmaker(t, map[string]float64{"widen-alice": 1200, "widen-bob": 1300})
+	alice, bob := &recordConn{}, &recordConn{}
+
+	m.Join("widen-alice", alice)
+	m.Join("widen-bob", bob)
+	if m.queue.Len() != 2 {
+		t.Fatalf("queue has %d players, want both waiting", m.queue.Len())
+	}
+
+	clock.advance(3 * time.Second) // window is 50 + 0.1*350 = 85
+	m.step()
+	if m.queue.Len() != 2 {
+		t.Fatal("players paired before their windows overlapped")
+	}
+
+	clock.advance(2 * time.Second) // window is about 108
+	m.step()
+	if m.queue.Len() != 0 {
+		t.Fatalf("queue has %d players after the windows overlapped", m.queue.Len())
+	}
+	a, b := alice.started(), bob.started()
+	if a == nil || b == nil || a["gameId"] != b["gameId"] || a["opponent"] != "widen-bob" {
+		t.Fatalf("start messages = %v and %v, want one game against each other", a, b)
+	}
+}
+
+func TestMatchmakerBotAfterTimeout(t *testing.T) {
+	m, clock := newTestMatchmaker(t, map[string]float64{"timeout-carol": 1500})
+	carol := &recordConn{}
+
+	m.Join("timeout-carol", carol)
+	clock.advance(9 * time.Second)
+	if updates := m.step(); len(updates) != 1 || m.queue.Len() != 1 {
+		t.Fatalf("got %d updates and %d queued before the timeout, want carol still waiting", len(updates), m.queue.Len())
+	}
+	if carol.started() != nil {
+		t.Fatal("bot game started before the timeout")
+	}
+
+	clock.advance(time.Second)
+	if updates := m.step(); len(updates) != 0 || m.queue.Len() != 0 {
+		t.Fatal("carol still queued at the timeout")
+	}
+	start := carol.started()
+	if start == nil || start["opponent"] != game.BotUsername {
+		t.Fatalf("start = %v, want a game against the bot", start)
+	}
+}
+
+func TestMatchmakerLeaveCancelsBot(t *testing.T) {
+	m, clock := newTestMatchmaker(t, nil)
+	dave := &recordConn{}
+
+	m.Join("leave-dave", dave)
+	if !m.Leave("leave-dave", dave) {
+		t.Fatal("Leave reported dave wasn't queued")
+	}
+	clock.advance(time.Minute)
+	m.step()
+	if dave.started() != nil {
+		t.Fatal("a player who left the queue still got a bot game")
+	}
+}
+
+func TestBotMoveDoesNotHoldGameLock(t *testing.T) {
+	m, clock := newTestMatchmaker(t, nil)
+	m.botThinkTime = 300 * time.Millisecond
+	erin := &recordConn{}
+	m.Join("think-erin", erin)
+	clock.advance(m.timeout)
+	m.step()
+	g := game.FindGameByPlayerName(m.srv.store, "think-erin")
+	if g == nil {
+		t.Fatal("no bot game started")
+	}
+
+	g.Lock()
+	m.HandleMove(g, "think-erin", 3)
+	g.Unlock()
+
+	// Chat, spectators and timers can take the lock while the bot thinks
+	locked := make(chan struct{})
+	go func() {
+		g.Lock()
+		g.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("the game lock was held through the bot's think time")
+	}
+
+	waitUntil(t, "the bot replies", func() bool {
+		g.Lock()
+		defer g.Unlock()
+		return len(g.Moves) == 2 && g.CurrentTurn != "cpu"
+	})
+}
+
+// stuckConn is a socket whose writes block until release is closed
+type stuckConn struct{ release chan struct{} }
+
+func (c *stuckConn) WriteJSON(v interface{}) error { <-c.release; return nil }
+func (c *stuckConn) Close() error                  { return nil }
+
+func TestJoinWritesOutsideQueueLock(t *testing.T) {
+	m, _ := newTestMatchmaker(t, map[string]float64{"stuck-frank": 1000, "quick-gina": 2000})
+	stuck := &stuckConn{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	go m.Join("stuck-frank", stuck)
+	waitUntil(t, "frank is queued", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.queue.Len() == 1
+	})
+
+	joined := make(chan struct{})
+	go func() {
+		m.Join("quick-gina", &recordConn{})
+		close(joined)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(time.Second):
+		t.Fatal("a player whose socket is stuck held up the next join")
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"fourinrow/db"
+)
+
+// Rating search window: a fresh entry only accepts opponents within
+// MinSearchWindow points, widening linearly to MaxSearchWindow once it has
+// waited the configured widen time (SEARCH_WINDOW_TIME).
+const (
+	MinSearchWindow = 50.0
+	MaxSearchWindow = 400.0
+)
+
+// SearchWindow is how far from their own rating an entry will accept an
+// opponent after waiting for the given time, when the window takes widen
+// to open fully
+func SearchWindow(waited, widen time.Duration) float64 {
+	if waited <= 0 {
+		return MinSearchWindow
+	}
+	if waited >= widen {
+		return MaxSearchWindow
+	}
+	frac := float64(waited) / float64(widen)
+	return MinSearchWindow + frac*(MaxSearchWindow-MinSearchWindow)
+}
+
+// canPair reports whether two queue entries are close enough in rating.
+// Either side's widened window is enough, so a long wait helps both players.
+func canPair(a, b *QueueEntry, now time.Time, widen time.Duration) bool {
+	if a.Player.Username == b.Player.Username {
+		return false
+	}
+	window := math.Max(SearchWindow(a.Waited(now), widen), SearchWindow(b.Waited(now), widen))
+	return math.Abs(a.Rating-b.Rating) <= window
+}
+
+// FindOpponent picks the best waiting opponent for e: the closest rating that
+// either window accepts, with the longest-waiting entry winning ties.
+// It returns nil if nobody in the queue is acceptable.
+func FindOpponent(q *MatchQueue, e *QueueEntry, now time.Time, widen time.Duration) *QueueEntry {
+	var best *QueueEntry
+	bestDiff := math.Inf(1)
+	for _, c := range q.Entries() {
+		if c == e || !canPair(e, c, now, widen) {
+			continue
+		}
+		// Entries are oldest first, so strict < keeps the longer wait on ties
+		if diff := math.Abs(e.Rating - c.Rating); diff < bestDiff {
+			best, bestDiff = c, diff
+		}
+	}
+	return best
+}
+
+// PairWaiting matches up queued entries whose windows now overlap, oldest
+// first. It returns the pairs and leaves them out of the queue.
+func PairWaiting(q *MatchQueue, now time.Time, widen time.Duration) [][2]*QueueEntry {
+	var pairs [][2]*QueueEntry
+	for i := 0; i < q.Len(); i++ {
+		e := q.Entries()[i]
+		if opp := FindOpponent(q, e, now, widen); opp != nil {
+			q.Remove(e)
+			q.Remove(opp)
+			pairs = append(pairs, [2]*QueueEntry{e, opp})
+			i = -1 // the slice shifted; rescan from the oldest entry
+		}
+	}
+	return pairs
+}
+
+// dbRating reads a player's rating, falling back to the default if the DB
+// is unavailable
+func dbRating(username string) float64 {
+	if db.Repo == nil {
+		return db.DefaultRating
+	}
+	rating, err := db.Repo.GetRating(username)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load rating for %s: %v", username, err)
+	}
+	return rating
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fourinrow/db"
+)
+
+type recentGames struct {
+	Games  []db.StoredGame `json:"games"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+type playerProfile struct {
+	*db.PlayerStats
+	Rating      float64     `json:"rating"`
+	RecentGames recentGames `json:"recentGames"`
+}
+
+// headToHeadGame links a rivalry game to its replay
+type headToHeadGame struct {
+	db.HeadToHeadGame
+	ReplayURL string `json:"replayUrl"`
+}
+
+type headToHead struct {
+	*db.HeadToHead
+	Recent []headToHeadGame `json:"recentGames"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+// PlayersHandler serves players:
+//
+//	GET /api/players/{username}         profile and stats
+//	GET /api/players/{a}/vs/{b}         a's record, games and rating movement against b
+//
+// The games lists page with limit and offset (newest first).
+func PlayersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/players/")
+	username, opponent, vs := strings.Cut(path, "/vs/")
+	if username == "" || strings.Contains(username, "/") || (vs && (opponent == "" || strings.Contains(opponent, "/"))) {
+		http.NotFound(w, r)
+		return
+	}
+	if vs && username == opponent {
+		http.Error(w, "a player has no record against themselves", http.StatusBadRequest)
+		return
+	}
+	if db.Repo == nil {
+		http.Error(w, "DB unavailable", 503)
+		return
+	}
+	if vs {
+		serveHeadToHead(w, r, username, opponent)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, err := parsePage(q.Get("limit"), q.Get("offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := db.Repo.GetPlayerStats(username)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load stats for %s: %v", username, err)
+		http.Error(w, "failed to load player", http.StatusInternalServerError)
+		return
+	}
+	games, err := db.Repo.ListGamesForPlayer(username, limit, offset)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to list games for %s: %v", username, err)
+		http.Error(w, "failed to load player", http.StatusInternalServerError)
+		return
+	}
+	if games == nil {
+		games = []db.StoredGame{}
+	}
+
+	profile := playerProfile{
+		PlayerStats: stats,
+		Rating:      dbRating(username),
+		RecentGames: recentGames{Games: games, Limit: limit, Offset: offset},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func serveHeadToHead(w http.ResponseWriter, r *http.Request, player, opponent string) {
+	q := r.URL.Query()
+	limit, offset, err := parsePage(q.Get("limit"), q.Get("offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h, err := db.Repo.GetHeadToHead(player, opponent, limit, offset)
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load %s vs %s: %v", player, opponent, err)
+		http.Error(w, "failed to load head-to-head", http.StatusInternalServerError)
+		return
+	}
+
+	resp := headToHead{HeadToHead: h, Recent: make([]headToHeadGame, len(h.Recent)), Limit: limit, Offset: offset}
+	for i, g := range h.Recent {
+		resp.Recent[i] = headToHeadGame{HeadToHeadGame: g, ReplayURL: "/api/games/" + url.PathEscape(g.ID) + "/replay"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
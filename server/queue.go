@@ -0,0 +1,75 @@
+package server
+
+import (
+	"time"
+
+	"fourinrow/game"
+)
+
+// QueueEntry is one player waiting for an opponent
+type QueueEntry struct {
+	Player   *game.Player
+	Rating   float64
+	JoinedAt time.Time
+}
+
+// Waited reports how long the entry has been queued as of now
+func (e *QueueEntry) Waited(now time.Time) time.Duration {
+	return now.Sub(e.JoinedAt)
+}
+
+// MatchQueue keeps waiting players in arrival order. It is not safe for
+// concurrent use; the Matchmaker guards it with its own mutex.
+type MatchQueue struct {
+	entries []*QueueEntry
+}
+
+func (q *MatchQueue) Len() int { return len(q.entries) }
+
+// Entries returns the waiting players, oldest first
+func (q *MatchQueue) Entries() []*QueueEntry { return q.entries }
+
+func (q *MatchQueue) Push(e *QueueEntry) {
+	q.entries = append(q.entries, e)
+}
+
+// Find returns the entry for username, or nil if they aren't queued
+func (q *MatchQueue) Find(username string) *QueueEntry {
+	for _, e := range q.entries {
+		if e.Player.Username == username {
+			return e
+		}
+	}
+	return nil
+}
+
+// Position is the 1-based place of username in the queue, or 0 if absent
+func (q *MatchQueue) Position(username string) int {
+	for i, e := range q.entries {
+		if e.Player.Username == username {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Remove drops the entry. It reports whether it was queued.
+func (q *MatchQueue) Remove(e *QueueEntry) bool {
+	for i, x := range q.entries {
+		if x == e {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// PopFront removes and returns the oldest entry
+func (q *MatchQueue) PopFront() *QueueEntry {
+	if len(q.entries) == 0 {
+		return nil
+	}
+	e := q.entries[0]
+	q.Remove(e)
+	return e
+}
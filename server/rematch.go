@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoFinishedGame   = errors.New("no finished game to rematch")
+	ErrRematchBot       = errors.New("rematch is only available against human opponents")
+	ErrOpponentGone     = errors.New("opponent is no longer available")
+	ErrNoRematchOffer   = errors.New("no rematch offer to accept")
+	ErrRematchAlreadyOn = errors.New("rematch already started")
+)
+
+// HandleRematchOffer records username's offer on their last game. If the
+// opponent had already offered, the rematch starts right away.
+func (s *Server) HandleRematchOffer(username string) error {
+	s.rematchMu.Lock()
+	defer s.rematchMu.Unlock()
+
+	g, opponent, err := s.rematchCandidate(username)
+	if err != nil {
+		return err
+	}
+	g.Lock()
+	defer g.Unlock()
+
+	if g.RematchOfferedBy == opponent.Username {
+		return s.startRematch(g)
+	}
+
+	g.RematchOfferedBy = username
+	game.Save(s.store, g)
+	log.Printf("[REMATCH] %s offered a rematch of game %s", username, g.ID)
+	opponent.Conn.WriteJSON(game.WSMessage{Type: "rematch_offer", Payload: map[string]interface{}{
+		"gameId": g.ID, "from": username,
+	}})
+	return nil
+}
+
+// HandleRematchAccept starts the rematch the opponent offered
+func (s *Server) HandleRematchAccept(username string) error {
+	s.rematchMu.Lock()
+	defer s.rematchMu.Unlock()
+
+	g, opponent, err := s.rematchCandidate(username)
+	if err != nil {
+		return err
+	}
+	g.Lock()
+	defer g.Unlock()
+	if g.RematchOfferedBy != opponent.Username {
+		return ErrNoRematchOffer
+	}
+	return s.startRematch(g)
+}
+
+// rematchCandidate finds the finished game a rematch would replay
+func (s *Server) rematchCandidate(username string) (*game.Game, *game.Player, error) {
+	g := game.FindLatestGameByPlayerName(s.store, username)
+	if g == nil || g.Status != "finished" {
+		return nil, nil, ErrNoFinishedGame
+	}
+	if g.RematchGameID != "" {
+		return nil, nil, ErrRematchAlreadyOn
+	}
+
+	var opponent *game.Player
+	for _, p := range g.Players {
+		if p.Username != username {
+			opponent = p
+		}
+	}
+	if opponent == nil || opponent.IsBot {
+		return nil, nil, ErrRematchBot
+	}
+	if !opponent.IsConnected || game.FindGameByPlayerName(s.store, opponent.Username) != nil {
+		return nil, nil, ErrOpponentGone
+	}
+	return g, opponent, nil
+}
+
+// startRematch plays the same two players again on their open connections,
+// with colors swapped, as the next game in their series. Callers hold
+// prev's lock.
+func (s *Server) startRematch(prev *game.Game) error {
+	// The series starts with the game being rematched
+	if prev.Series == nil {
+		prev.Series = game.NewSeries(uuid.New().String(), prev)
+		prev.Series.GameIDs = append(prev.Series.GameIDs, prev.ID)
+		prev.Series.Record(prev)
+	}
+
+	var first, second *game.Player
+	for _, p := range prev.Players {
+		np := &game.Player{ID: uuid.New().String(), Username: p.Username, Conn: p.Conn, IsConnected: true, Rating: p.Rating}
+		if change, ok := prev.RatingChanges[p.Username]; ok {
+			np.Rating = change.After
+		}
+		// Whoever had color 2 moves first this time
+		if p.Color == 2 {
+			first = np
+		} else {
+			second = np
+		}
+	}
+
+	opts := GameOptions{Variant: prev.Variant, Series: prev.Series}
+	if prev.Clock != nil {
+		opts.ClockInitial = prev.Clock.Initial
+		opts.ClockIncrement = prev.Clock.Increment
+	}
+
+	log.Printf("[REMATCH] Rematch of %s: %s vs %s (series %s)", prev.ID, first.Username, second.Username, prev.Series.ID)
+	next := s.StartGameWithOptions(first, second, opts)
+	if next == nil {
+		return game.ErrStoreFull
+	}
+	prev.RematchGameID = next.ID
+	prev.RematchOfferedBy = ""
+	game.Save(s.store, prev)
+	return nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"fourinrow/config"
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+// finish ends g as won by username and stores it
+func finish(t *testing.T, srv *Server, g *game.Game, username string) {
+	t.Helper()
+	g.Lock()
+	defer g.Unlock()
+	g.Status, g.Winner = "finished", g.Players[username].ID
+	game.Save(srv.store, g)
+}
+
+func TestRematchSwapsColorsAndLinksSeries(t *testing.T) {
+	useStubAnalytics()
+	srv := newServer(config.Default(), game.NewMemoryStore(game.MaxGames))
+	first := storetest.NewGame("alice", "bob", time.Now())
+	for _, p := range first.Players {
+		p.IsConnected, p.Conn = true, &recordConn{}
+	}
+	if err := srv.store.Add(first); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	finish(t, srv, first, "alice")
+
+	if err := srv.HandleRematchAccept("bob"); err != ErrNoRematchOffer {
+		t.Fatalf("accept without an offer: %v, want ErrNoRematchOffer", err)
+	}
+	rematch := func(from, to string) *game.Game {
+		t.Helper()
+		prev := game.FindLatestGameByPlayerName(srv.store, from)
+		if err := srv.HandleRematchOffer(from); err != nil {
+			t.Fatalf("%s offers: %v", from, err)
+		}
+		if err := srv.HandleRematchAccept(to); err != nil {
+			t.Fatalf("%s accepts: %v", to, err)
+		}
+		next := game.GetGame(srv.store, prev.RematchGameID)
+		if next == nil {
+			t.Fatalf("no rematch of %s", prev.ID)
+		}
+		for name, p := range next.Players {
+			if p.Color != 3-prev.Players[name].Color {
+				t.Errorf("%s had color %d and has %d in the rematch", name, prev.Players[name].Color, p.Color)
+			}
+			if p.Color == 1 && next.CurrentTurn != p.ID {
+				t.Errorf("%s has color 1 but doesn't move first", name)
+			}
+		}
+		if next.Series == nil || next.Series != prev.Series {
+			t.Fatal("the rematch isn't in the same series as the game before it")
+		}
+		return next
+	}
+
+	second := rematch("alice", "bob")
+	if want := []string{first.ID, second.ID}; !reflect.DeepEqual(second.Series.GameIDs, want) {
+		t.Errorf("series games %v, want %v", second.Series.GameIDs, want)
+	}
+	if second.Series.Score["alice"] != 1 {
+		t.Errorf("series score %v, want alice on 1", second.Series.Score)
+	}
+
+	// The series carries on through the next rematch
+	finish(t, srv, second, "bob")
+	third := rematch("bob", "alice")
+	if want := []string{first.ID, second.ID, third.ID}; !reflect.DeepEqual(third.Series.GameIDs, want) {
+		t.Errorf("series games %v, want %v", third.Series.GameIDs, want)
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fourinrow/db"
+	"fourinrow/game"
+
+	"github.com/gorilla/websocket"
+)
+
+// Replays wait each move's real think time divided by the speed, clamped so
+// a long think doesn't stall the stream and a blitz doesn't blur
+const (
+	DefaultReplaySpeed = 1.0
+	MinReplaySpeed     = 0.25
+	MaxReplaySpeed     = 16.0
+	minReplayDelay     = 100 * time.Millisecond
+	maxReplayDelay     = 3 * time.Second
+)
+
+// A replay viewer that stops answering pings is dropped, even while paused
+const (
+	replayPongWait   = 60 * time.Second
+	replayPingPeriod = replayPongWait * 9 / 10
+)
+
+// ReplayFrame is the board after one ply. Frame 0 is the empty board.
+type ReplayFrame struct {
+	Ply   int            `json:"ply"`
+	Move  *db.StoredMove `json:"move,omitempty"`
+	Board [6][7]int      `json:"board"`
+}
+
+type gameResponse struct {
+	Game  *db.StoredGame  `json:"game"`
+	Moves []db.StoredMove `json:"moves"`
+}
+
+type replayResponse struct {
+	Game   *db.StoredGame `json:"game"`
+	Frames []ReplayFrame  `json:"frames"`
+}
+
+// GameHandler serves saved games:
+//
+//	GET /api/games/{id}         the game record and its moves
+//	GET /api/games/{id}/replay  the board after every ply
+func GameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, view, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/games/"), "/")
+	if id == "" || (view != "" && view != "replay") {
+		http.NotFound(w, r)
+		return
+	}
+	if db.Repo == nil {
+		http.Error(w, "DB unavailable", 503)
+		return
+	}
+
+	sg, moves, err := loadGame(id)
+	if errors.Is(err, db.ErrGameNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[DB ERROR] Failed to load game %s: %v", id, err)
+		http.Error(w, "failed to load game", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if view == "replay" {
+		json.NewEncoder(w).Encode(replayResponse{Game: sg, Frames: buildReplay(sg, moves)})
+		return
+	}
+	if moves == nil {
+		moves = []db.StoredMove{}
+	}
+	json.NewEncoder(w).Encode(gameResponse{Game: sg, Moves: moves})
+}
+
+func loadGame(id string) (*db.StoredGame, []db.StoredMove, error) {
+	sg, err := db.Repo.GetGame(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	moves, err := db.Repo.GetMoves(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sg, moves, nil
+}
+
+// buildReplay replays the moves onto an empty board. Player1 had color 1.
+func buildReplay(sg *db.StoredGame, moves []db.StoredMove) []ReplayFrame {
+	frames := make([]ReplayFrame, 0, len(moves)+1)
+	frames = append(frames, ReplayFrame{})
+	var board [6][7]int
+	for i := range moves {
+		m := &moves[i]
+		color := 2
+		if m.Player == sg.Player1 {
+			color = 1
+		}
+		if m.Row >= 0 && m.Row < 6 && m.Column >= 0 && m.Column < 7 {
+			board[m.Row][m.Column] = color
+		}
+		frames = append(frames, ReplayFrame{Ply: m.Ply, Move: m, Board: board})
+	}
+	return frames
+}
+
+// replayDelay is how long to wait before showing a move at speed
+func replayDelay(m *db.StoredMove, speed float64) time.Duration {
+	d := time.Duration(float64(m.ThinkMs) / speed * float64(time.Millisecond))
+	return min(max(d, minReplayDelay), maxReplayDelay)
+}
+
+// parseReplaySpeed reads a speed multiplier, clamped to the allowed range
+func parseReplaySpeed(s string) (float64, error) {
+	if s == "" {
+		return DefaultReplaySpeed, nil
+	}
+	speed, err := strconv.ParseFloat(s, 64)
+	if err != nil || speed <= 0 {
+		return 0, errInvalidParam("speed")
+	}
+	return min(max(speed, MinReplaySpeed), MaxReplaySpeed), nil
+}
+
+// handleReplay serves /ws?replay=GAME_ID&speed=N, streaming a saved game one
+// "replay_frame" at a time. The viewer can send "replay_speed" (payload: the
+// new multiplier), "replay_pause", "replay_resume" and "replay_seek"
+// (payload: the ply to show next); the stream ends with "replay_end" and the
+// socket closes.
+func handleReplay(w http.ResponseWriter, r *http.Request, gameID string) {
+	speed, speedErr := parseReplaySpeed(r.URL.Query().Get("speed"))
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	conn := newWSConn(ws)
+
+	if speedErr != nil {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: speedErr.Error()})
+		return
+	}
+	if db.Repo == nil {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: "DB unavailable"})
+		return
+	}
+	sg, moves, err := loadGame(gameID)
+	if err != nil {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		return
+	}
+
+	// Only this goroutine writes; the reader just forwards controls
+	controls := make(chan game.WSMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go readReplayControls(ws, controls, done)
+	ping := time.NewTicker(replayPingPeriod)
+	defer ping.Stop()
+
+	frames := buildReplay(sg, moves)
+	conn.WriteJSON(game.WSMessage{Type: "replay_start", Payload: map[string]interface{}{
+		"game": sg, "frames": len(frames), "speed": speed,
+	}})
+	conn.WriteJSON(game.WSMessage{Type: "replay_frame", Payload: frames[0]})
+
+	paused := false
+	for i := 1; i < len(frames); {
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if !paused {
+			timer = time.NewTimer(replayDelay(frames[i].Move, speed))
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			if err := conn.WriteJSON(game.WSMessage{Type: "replay_frame", Payload: frames[i]}); err != nil {
+				return
+			}
+			i++
+		case <-ping.C:
+			if timer != nil {
+				timer.Stop()
+			}
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case msg, ok := <-controls:
+			// A control restarts the wait for the current move
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				return // viewer went away
+			}
+			switch msg.Type {
+			case "replay_pause":
+				paused = true
+			case "replay_resume":
+				paused = false
+			case "replay_speed":
+				if v, ok := msg.Payload.(float64); ok && v > 0 {
+					speed = min(max(v, MinReplaySpeed), MaxReplaySpeed)
+				}
+			case "replay_seek":
+				ply, ok := msg.Payload.(float64)
+				if !ok {
+					break
+				}
+				// Show the board at that ply now, then carry on from there
+				j := min(max(int(ply), 0), len(frames)-1)
+				if err := conn.WriteJSON(game.WSMessage{Type: "replay_frame", Payload: frames[j]}); err != nil {
+					return
+				}
+				i = j + 1
+			}
+		}
+	}
+	conn.WriteJSON(game.WSMessage{Type: "replay_end", Payload: sg.ID})
+}
+
+// readReplayControls forwards the viewer's controls until the socket fails
+// or goes quiet for longer than replayPongWait
+func readReplayControls(conn *websocket.Conn, controls chan<- game.WSMessage, done <-chan struct{}) {
+	defer close(controls)
+	conn.SetReadDeadline(time.Now().Add(replayPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(replayPongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(replayPongWait))
+		var msg game.WSMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		select {
+		case controls <- msg:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fourinrow/db"
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReplaySeek(t *testing.T) {
+	prev := db.Repo
+	db.Repo = db.NewMemoryRepository()
+	t.Cleanup(func() { db.Repo = prev })
+
+	// Five moves, two seconds apart, so nothing plays on its own during the test
+	start := time.Now().Add(-time.Minute)
+	g := storetest.NewGame("alice", "bob", start)
+	ids := map[int]string{}
+	for _, p := range g.Players {
+		ids[p.Color] = p.ID
+	}
+	for ply := 1; ply <= 5; ply++ {
+		g.Moves = append(g.Moves, game.Move{
+			Ply: ply, PlayerID: ids[2-ply%2], Column: ply % 2, Row: 5 - (ply-1)/2,
+			At: start.Add(time.Duration(ply) * 2 * time.Second),
+		})
+	}
+	g.Status, g.Winner = "finished", ids[1]
+	if _, err := db.Repo.SaveGame(g); err != nil {
+		t.Fatalf("SaveGame: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleReplay(w, r, g.ID)
+	}))
+	t.Cleanup(ts.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	next := func() testMessage {
+		t.Helper()
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		var msg testMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return msg
+	}
+	expectFrame := func(ply int) {
+		t.Helper()
+		msg := next()
+		var f ReplayFrame
+		json.Unmarshal(msg.Payload, &f)
+		if msg.Type != "replay_frame" || f.Ply != ply {
+			t.Fatalf("got %s %s, want the frame for ply %d", msg.Type, msg.Payload, ply)
+		}
+	}
+	control := func(typ string, payload interface{}) {
+		t.Helper()
+		if err := ws.WriteJSON(game.WSMessage{Type: typ, Payload: payload}); err != nil {
+			t.Fatalf("send %s: %v", typ, err)
+		}
+	}
+
+	if msg := next(); msg.Type != "replay_start" {
+		t.Fatalf("first message %s, want replay_start", msg.Type)
+	}
+	expectFrame(0)
+
+	control("replay_pause", nil)
+	control("replay_seek", 3)
+	expectFrame(3)
+
+	// Seeking past the end shows the final board and ends the replay
+	control("replay_seek", 99)
+	expectFrame(5)
+	if msg := next(); msg.Type != "replay_end" {
+		t.Fatalf("got %s after the last frame, want replay_end", msg.Type)
+	}
+}
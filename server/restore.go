@@ -0,0 +1,96 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"fourinrow/game"
+)
+
+// RestoreGrace is how long players get to reconnect to a game restored after
+// a restart before they forfeit. If nobody comes back the game is abandoned.
+const RestoreGrace = 2 * time.Minute
+
+// RestoreGames reloads every snapshotted game into the store at boot.
+// Players come back disconnected and are reattached by Reconnect when they
+// open a websocket again.
+func (s *Server) RestoreGames(snaps game.Snapshotter) int {
+	games, err := snaps.LoadSnapshots()
+	if err != nil {
+		log.Printf("[RESTORE] Failed to load snapshots: %v", err)
+		return 0
+	}
+
+	restored := 0
+	for _, g := range games {
+		if g.Status != "playing" {
+			snaps.DeleteSnapshot(g.ID)
+			continue
+		}
+
+		// A shared store may already hold a newer copy than our snapshot
+		if cur, err := s.store.Get(g.ID); err == nil {
+			g = cur
+		}
+		if err := s.adopt(g); err != nil {
+			log.Printf("[RESTORE] Could not restore game %s: %v", g.ID, err)
+			continue
+		}
+
+		// Downtime isn't charged to whoever was on move
+		g.Lock()
+		if g.Clock != nil {
+			g.Clock.Start(time.Now())
+			s.armClock(g)
+		}
+		for _, p := range g.Players {
+			if !p.IsBot {
+				s.startRestoreTimer(g, p, s.restoreGrace)
+			}
+		}
+		// Snapshotted between a human's move and the bot's reply
+		if g.CurrentTurn == "cpu" {
+			s.matchmaker.scheduleBotMove(g)
+		}
+		g.Unlock()
+		restored++
+	}
+
+	if restored > 0 {
+		log.Printf("[RESTORE] ✅ Restored %d in-progress games", restored)
+	}
+	return restored
+}
+
+func (s *Server) adopt(g *game.Game) error {
+	if a, ok := s.store.(game.Adopter); ok {
+		return a.Adopt(g)
+	}
+	return s.store.Add(g)
+}
+
+// startRestoreTimer is startForfeitTimer for a restored game, except that if
+// no human has come back when it fires the game ends with no result instead
+// of going to another absent player. Callers hold g's lock.
+func (s *Server) startRestoreTimer(g *game.Game, player *game.Player, d time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		g.Lock()
+		defer g.Unlock()
+		if player.DisconnectTimer != timer || player.IsConnected || g.Status != "playing" {
+			return
+		}
+		for _, p := range g.Players {
+			if !p.IsBot && p.IsConnected {
+				s.forfeit(g, player.Username)
+				return
+			}
+		}
+		log.Printf("[RESTORE] Nobody came back to game %s, abandoning it", g.ID)
+		g.Status = "finished"
+		g.EndReason = game.EndAbandoned
+		g.Winner = ""
+		s.HandleGameOver(g)
+	})
+	player.DisconnectTimer = timer
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"fourinrow/config"
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+)
+
+// snapshotList is a Snapshotter holding a fixed set of games
+type snapshotList []*game.Game
+
+func (l snapshotList) SaveSnapshot(g *game.Game) error      { return nil }
+func (l snapshotList) DeleteSnapshot(id string) error       { return nil }
+func (l snapshotList) LoadSnapshots() ([]*game.Game, error) { return l, nil }
+
+func newRestoreServer(t *testing.T) *Server {
+	t.Helper()
+	useStubAnalytics()
+	srv := newServer(config.Default(), game.NewMemoryStore(game.MaxGames))
+	srv.restoreGrace = 50 * time.Millisecond
+	srv.matchmaker.botThinkTime = 0
+	return srv
+}
+
+func TestRestoreBotGameOnBotsTurn(t *testing.T) {
+	srv := newRestoreServer(t)
+	srv.restoreGrace = time.Minute
+	g := storetest.NewGame("alice", "bob", time.Now())
+	delete(g.Players, "bob")
+	g.Players["cpu"] = &game.Player{ID: "cpu", Username: game.BotUsername, Color: 2, IsBot: true, IsConnected: true, GameID: g.ID}
+	game.ApplyMove(g, g.Players["alice"].ID, 3)
+	if g.CurrentTurn != "cpu" {
+		t.Fatalf("turn after alice's move = %q, want the bot's", g.CurrentTurn)
+	}
+
+	if n := srv.RestoreGames(snapshotList{g}); n != 1 {
+		t.Fatalf("restored %d games, want 1", n)
+	}
+	waitUntil(t, "the bot replies", func() bool {
+		g.Lock()
+		defer g.Unlock()
+		return len(g.Moves) == 2
+	})
+}
+
+func TestRestoreAbandonsGameNobodyReturnsTo(t *testing.T) {
+	srv := newRestoreServer(t)
+	g := storetest.NewGame("alice", "bob", time.Now())
+
+	srv.RestoreGames(snapshotList{g})
+	waitUntil(t, "the game ends", func() bool {
+		g.Lock()
+		defer g.Unlock()
+		return g.Status == "finished"
+	})
+	if g.EndReason != game.EndAbandoned || g.Winner != "" {
+		t.Fatalf("game ended %s won by %q, want abandoned with no winner", g.EndReason, g.Winner)
+	}
+}
+
+func TestRestoreForfeitsPlayerWhoDoesNotReturn(t *testing.T) {
+	srv := newRestoreServer(t)
+	srv.restoreGrace = 200 * time.Millisecond
+	g := storetest.NewGame("alice", "bob", time.Now())
+
+	srv.RestoreGames(snapshotList{g})
+	g.Lock()
+	alice := g.Players["alice"]
+	alice.Conn, alice.IsConnected = &recordConn{}, true
+	alice.DisconnectTimer.Stop()
+	alice.DisconnectTimer = nil
+	g.Unlock()
+
+	waitUntil(t, "the game ends", func() bool {
+		g.Lock()
+		defer g.Unlock()
+		return g.Status == "finished"
+	})
+	if g.EndReason != game.EndDisconnect || g.Winner != alice.ID {
+		t.Fatalf("game ended %s won by %q, want alice (%s) to win by forfeit", g.EndReason, g.Winner, alice.ID)
+	}
+}
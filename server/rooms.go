@@ -0,0 +1,275 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"fourinrow/game"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RoomTTL        = 15 * time.Minute
+	roomCodeLength = 6
+	// No 0/O or 1/I/L, so codes can be read out loud
+	roomCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+)
+
+// Supported variants. Only the classic 6x7 board exists today.
+var Variants = map[string]bool{"classic": true}
+
+// Who gets color 1 and the first move in a room game
+const (
+	FirstMoveCreator = "creator"
+	FirstMoveJoiner  = "joiner"
+	FirstMoveRandom  = "random"
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found or expired")
+	ErrRoomFull     = errors.New("room is full")
+)
+
+// GameOptions are the settings a game is started with
+type GameOptions struct {
+	Variant        string
+	ClockInitial   time.Duration // zero means untimed
+	ClockIncrement time.Duration
+	Series         *game.Series // set for rematches
+}
+
+func DefaultGameOptions() GameOptions {
+	return GameOptions{Variant: "classic"}
+}
+
+// RoomOptions is what the room creator chooses
+type RoomOptions struct {
+	Variant          string `json:"variant"`
+	ClockSeconds     int    `json:"clockSeconds"` // 0 = untimed
+	IncrementSeconds int    `json:"incrementSeconds"`
+	FirstMove        string `json:"firstMove"` // creator, joiner or random
+}
+
+func (o *RoomOptions) normalize() error {
+	if o.Variant == "" {
+		o.Variant = "classic"
+	}
+	if !Variants[o.Variant] {
+		return errors.New("unknown variant")
+	}
+	if o.ClockSeconds < 0 || o.ClockSeconds > 3600 || o.IncrementSeconds < 0 || o.IncrementSeconds > 60 {
+		return errors.New("clock must be 0-3600s with a 0-60s increment")
+	}
+	if o.ClockSeconds == 0 && o.IncrementSeconds > 0 {
+		return errors.New("increment needs a clock")
+	}
+	switch o.FirstMove {
+	case "":
+		o.FirstMove = FirstMoveCreator
+	case FirstMoveCreator, FirstMoveJoiner, FirstMoveRandom:
+	default:
+		return errors.New("firstMove must be creator, joiner or random")
+	}
+	return nil
+}
+
+func (o RoomOptions) gameOptions() GameOptions {
+	return GameOptions{
+		Variant:        o.Variant,
+		ClockInitial:   time.Duration(o.ClockSeconds) * time.Second,
+		ClockIncrement: time.Duration(o.IncrementSeconds) * time.Second,
+	}
+}
+
+// Room is a private invite-only game waiting for its two players
+type Room struct {
+	Code      string      `json:"code"`
+	CreatedBy string      `json:"createdBy"`
+	Options   RoomOptions `json:"options"`
+	CreatedAt time.Time   `json:"createdAt"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+
+	waiting *game.Player
+	timer   *time.Timer
+}
+
+// RoomManager holds srv's open rooms
+type RoomManager struct {
+	srv   *Server
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+func NewRoomManager(srv *Server) *RoomManager {
+	return &RoomManager{srv: srv, rooms: make(map[string]*Room)}
+}
+
+// Create opens a room and schedules it to expire if nobody uses it
+func (rm *RoomManager) Create(createdBy string, opts RoomOptions) (*Room, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	code := newRoomCode()
+	for rm.rooms[code] != nil {
+		code = newRoomCode()
+	}
+
+	now := time.Now()
+	room := &Room{Code: code, CreatedBy: createdBy, Options: opts, CreatedAt: now, ExpiresAt: now.Add(RoomTTL)}
+	room.timer = time.AfterFunc(RoomTTL, func() { rm.expire(room) })
+	rm.rooms[code] = room
+
+	log.Printf("[ROOMS] %s created room %s (%+v)", createdBy, code, opts)
+	return room, nil
+}
+
+func (rm *RoomManager) expire(room *Room) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.rooms[room.Code] != room {
+		return
+	}
+	delete(rm.rooms, room.Code)
+	log.Printf("[ROOMS] Room %s expired unused", room.Code)
+	if room.waiting != nil {
+		room.waiting.Conn.WriteJSON(game.WSMessage{Type: "error", Payload: ErrRoomNotFound.Error()})
+	}
+}
+
+// Join seats a player in the room. The second distinct player starts the game
+// and closes the room.
+func (rm *RoomManager) Join(code, username string, conn game.Conn) error {
+	if rm.srv.Reconnect(username, conn) {
+		return nil
+	}
+	rating := dbRating(username)
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	room := rm.rooms[code]
+	if room == nil {
+		return ErrRoomNotFound
+	}
+
+	player := &game.Player{ID: uuid.New().String(), Username: username, Conn: conn, IsConnected: true, Rating: rating}
+
+	// First arrival, or the same player reconnecting while they wait
+	if room.waiting == nil || room.waiting.Username == username {
+		room.waiting = player
+		conn.WriteJSON(game.WSMessage{Type: "waiting", Payload: "Waiting for your friend to join room " + code})
+		return nil
+	}
+
+	opponent := room.waiting
+	if opponent.Username != room.CreatedBy && username != room.CreatedBy {
+		return ErrRoomFull
+	}
+
+	room.timer.Stop()
+	delete(rm.rooms, code)
+
+	// Work out who moves first
+	creator, joiner := opponent, player
+	if player.Username == room.CreatedBy {
+		creator, joiner = player, opponent
+	}
+	first, second := creator, joiner
+	switch room.Options.FirstMove {
+	case FirstMoveJoiner:
+		first, second = joiner, creator
+	case FirstMoveRandom:
+		if n, _ := rand.Int(rand.Reader, big.NewInt(2)); n.Int64() == 1 {
+			first, second = joiner, creator
+		}
+	}
+
+	log.Printf("[ROOMS] Room %s starting: %s vs %s", code, first.Username, second.Username)
+	rm.srv.StartGameWithOptions(first, second, room.Options.gameOptions())
+	return nil
+}
+
+// Leave frees the waiting slot if conn is the one holding it
+func (rm *RoomManager) Leave(code, username string, conn game.Conn) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	room := rm.rooms[code]
+	if room == nil || room.waiting == nil || room.waiting.Conn != conn {
+		return false
+	}
+	room.waiting = nil
+	log.Printf("[ROOMS] %s left room %s", username, code)
+	return true
+}
+
+func newRoomCode() string {
+	b := make([]byte, roomCodeLength)
+	max := big.NewInt(int64(len(roomCodeAlphabet)))
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = roomCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+type createRoomRequest struct {
+	Username string `json:"username"`
+	RoomOptions
+}
+
+// RoomsHandler creates a private room: POST /api/rooms
+func (s *Server) RoomsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.Draining() {
+		http.Error(w, ErrServerRestarting.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var req createRoomRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Guests create rooms under their guest name
+	if req.Username == "" {
+		guest, cookie, err := s.resolveGuest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		req.Username = guest.Username
+		if cookie != nil {
+			http.SetCookie(w, cookie)
+		}
+	}
+
+	room, err := s.rooms.Create(req.Username, req.RoomOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+}
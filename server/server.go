@@ -0,0 +1,78 @@
+package server
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fourinrow/cluster"
+	"fourinrow/config"
+	"fourinrow/game"
+)
+
+// Server is one game server: the games it hosts, its matchmaking queue,
+// rooms, spectators and chat, and its cluster node when there is one.
+// Nothing is shared between servers but the database and analytics, so
+// several can run in one process, e.g. two cluster nodes in a test.
+type Server struct {
+	cfg   config.Config
+	store game.GameStore
+
+	matchmaker   *Matchmaker
+	rooms        *RoomManager
+	spectators   *SpectatorHub
+	chat         *ChatService
+	leaderboards *leaderboardCache
+
+	// sessionSecret signs the guest cookie
+	sessionSecret []byte
+
+	// rematchMu serializes offers and accepts so two simultaneous offers
+	// can't start two games
+	rematchMu sync.Mutex
+
+	// restoreGrace is how long players of a restored game get to come back
+	restoreGrace time.Duration
+
+	draining atomic.Bool
+	sockets  socketSet
+
+	// node is this server's cluster membership, nil for a single node
+	node  *cluster.Node
+	relay relayState
+}
+
+// New creates a server that keeps its games in store and starts matching
+// players. Call EnableCluster before serving if this is a cluster node.
+func New(cfg config.Config, store game.GameStore) *Server {
+	s := newServer(cfg, store)
+	go s.matchmaker.runQueueUpdates(QueueUpdateInterval)
+	return s
+}
+
+// newServer is New without the background queue updates
+func newServer(cfg config.Config, store game.GameStore) *Server {
+	s := &Server{
+		cfg:           cfg,
+		store:         store,
+		spectators:    NewSpectatorHub(MaxSpectatorsPerGame),
+		leaderboards:  newLeaderboardCache(cfg.LeaderboardTTL.Duration),
+		sessionSecret: loadSessionSecret(cfg.SessionSecret),
+		sockets:       socketSet{conns: make(map[*wsConn]bool)},
+		restoreGrace:  RestoreGrace,
+	}
+	s.matchmaker = newMatchmaker(s, time.Now, dbRating)
+	s.rooms = NewRoomManager(s)
+	s.chat = NewChatService(s)
+	return s
+}
+
+// PublishVars exports the store and leaderboard cache counters on
+// /debug/vars. The names are process-wide, so call it for one server only.
+func (s *Server) PublishVars() {
+	expvar.Publish("game_store", expvar.Func(func() any { return game.Stats(s.store) }))
+	expvar.Publish("leaderboard_cache", expvar.Func(func() any {
+		return map[string]any{"pages": s.leaderboards.pages.stats(), "positions": s.leaderboards.positions.stats()}
+	}))
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"fourinrow/cluster"
+	"fourinrow/game"
+)
+
+// drainPollInterval is how often Shutdown checks whether the last games
+// have finished when there is nowhere to snapshot them
+const drainPollInterval = 500 * time.Millisecond
+
+var ErrServerRestarting = errors.New("server is restarting, please reconnect in a moment")
+
+// Draining reports whether Shutdown has started. New matches are refused.
+func (s *Server) Draining() bool { return s.draining.Load() }
+
+// socketSet is the player websockets a server holds, so a shutdown can warn
+// and close them
+type socketSet struct {
+	sync.Mutex
+	conns map[*wsConn]bool
+}
+
+func (ss *socketSet) add(conn *wsConn) {
+	ss.Lock()
+	ss.conns[conn] = true
+	ss.Unlock()
+}
+
+func (ss *socketSet) remove(conn *wsConn) {
+	ss.Lock()
+	delete(ss.conns, conn)
+	ss.Unlock()
+}
+
+func (ss *socketSet) list() []*wsConn {
+	ss.Lock()
+	defer ss.Unlock()
+	conns := make([]*wsConn, 0, len(ss.conns))
+	for conn := range ss.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Shutdown drains this node before the process exits: it stops matchmaking,
+// tells everyone connected with a "server_restarting" message, then parks
+// the games it hosts. With a snapshot store configured games are snapshotted
+// right away and resume after the restart; otherwise Shutdown waits for them
+// to finish until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) {
+	if s.draining.Swap(true) {
+		return
+	}
+	log.Println("[SHUTDOWN] Draining: no new matches")
+	if s.node != nil {
+		// Hands the queue to another node if we were leading
+		s.node.SetDraining(true)
+	}
+	s.matchmaker.Drain()
+
+	// Our own sockets, plus players on other nodes whose game we host
+	restarting := game.WSMessage{Type: "server_restarting", Payload: ErrServerRestarting.Error()}
+	for _, conn := range s.sockets.list() {
+		conn.WriteJSON(restarting)
+	}
+	for _, g := range s.hostedGames() {
+		g.Lock()
+		for _, p := range g.Players {
+			if _, remote := p.Conn.(cluster.RemoteConn); remote {
+				p.Conn.WriteJSON(restarting)
+			}
+		}
+		g.Unlock()
+	}
+
+	if game.Snapshots == nil {
+		s.waitForGames(ctx)
+	}
+
+	for _, conn := range s.sockets.list() {
+		conn.Close()
+	}
+	parked := 0
+	for _, g := range s.hostedGames() {
+		s.parkGame(g)
+		parked++
+	}
+	if parked > 0 {
+		if game.Snapshots != nil {
+			log.Printf("[SHUTDOWN] Snapshotted %d games to resume after restart", parked)
+		} else {
+			log.Printf("[SHUTDOWN] ⚠️ Deadline reached with %d games unfinished", parked)
+		}
+	}
+	if s.node != nil {
+		s.node.Stop()
+	}
+}
+
+// waitForGames lets games already underway play out until ctx expires
+func (s *Server) waitForGames(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		live := len(s.hostedGames())
+		if live == 0 {
+			return
+		}
+		log.Printf("[SHUTDOWN] Waiting for %d games to finish", live)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// hostedGames are the games in progress that this node runs
+func (s *Server) hostedGames() []*game.Game {
+	games, err := s.store.List()
+	if err != nil {
+		log.Printf("[SHUTDOWN] Could not list games: %v", err)
+		return nil
+	}
+	var hosted []*game.Game
+	for _, g := range games {
+		if g.Status == "playing" && !s.hostedElsewhere(g) {
+			hosted = append(hosted, g)
+		}
+	}
+	return hosted
+}
+
+// parkGame stops every timer that could still change g and snapshots it
+func (s *Server) parkGame(g *game.Game) {
+	g.Lock()
+	defer g.Unlock()
+	stopClock(g)
+	for _, p := range g.Players {
+		if p.DisconnectTimer != nil {
+			p.DisconnectTimer.Stop()
+			p.DisconnectTimer = nil
+		}
+		p.IsConnected = p.IsBot
+	}
+	s.spectators.Close(g.ID)
+	game.Save(s.store, g)
+}
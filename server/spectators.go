@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"fourinrow/game"
+)
+
+const MaxSpectatorsPerGame = 50
+
+var (
+	ErrGameNotLive     = errors.New("game not found or already finished")
+	ErrTooManyWatchers = errors.New("this game has reached its spectator limit")
+)
+
+// SpectatorHub tracks the read-only connections watching each live game
+type SpectatorHub struct {
+	mu     sync.Mutex
+	byGame map[string]map[*wsConn]bool
+	limit  int
+}
+
+func NewSpectatorHub(limit int) *SpectatorHub {
+	return &SpectatorHub{byGame: make(map[string]map[*wsConn]bool), limit: limit}
+}
+
+// Add registers conn as a spectator of the game. Callers hold g's lock.
+func (h *SpectatorHub) Add(g *game.Game, conn *wsConn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if g.Status != "playing" {
+		return ErrGameNotLive
+	}
+	set := h.byGame[g.ID]
+	if set == nil {
+		set = make(map[*wsConn]bool)
+		h.byGame[g.ID] = set
+	}
+	if len(set) >= h.limit {
+		return ErrTooManyWatchers
+	}
+	set[conn] = true
+	g.SpectatorCount = len(set)
+	return nil
+}
+
+// Remove drops a single spectator, e.g. when their socket closes. Callers
+// hold g's lock.
+func (h *SpectatorHub) Remove(g *game.Game, conn *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	set := h.byGame[g.ID]
+	if set == nil {
+		return
+	}
+	delete(set, conn)
+	g.SpectatorCount = len(set)
+	if len(set) == 0 {
+		delete(h.byGame, g.ID)
+	}
+}
+
+// Count is the number of spectators watching gameID
+func (h *SpectatorHub) Count(gameID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.byGame[gameID])
+}
+
+// Broadcast sends msg to everyone watching the game. The writes happen
+// outside h.mu, so a slow spectator only holds up their own game.
+func (h *SpectatorHub) Broadcast(gameID string, msg game.WSMessage) {
+	h.mu.Lock()
+	conns := make([]*wsConn, 0, len(h.byGame[gameID]))
+	for conn := range h.byGame[gameID] {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.WriteJSON(msg)
+	}
+}
+
+// Close detaches every spectator once the game is over and closes their sockets
+func (h *SpectatorHub) Close(gameID string) {
+	h.mu.Lock()
+	set := h.byGame[gameID]
+	delete(h.byGame, gameID)
+	h.mu.Unlock()
+
+	for conn := range set {
+		conn.WriteJSON(game.WSMessage{Type: "spectate_end", Payload: gameID})
+		conn.Close()
+	}
+}
+
+// handleSpectator serves /ws?spectate=GAME_ID. Spectators only ever receive
+// "update" messages; anything they send is ignored.
+func (s *Server) handleSpectator(w http.ResponseWriter, r *http.Request, gameID string) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	// Broadcasts reach this socket from both players, the clock and chat
+	conn := newWSConn(ws)
+
+	g := game.GetGame(s.store, gameID)
+	if g == nil {
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: ErrGameNotLive.Error()})
+		conn.Close()
+		return
+	}
+	g.Lock()
+	if err := s.spectators.Add(g, conn); err != nil {
+		g.Unlock()
+		conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		conn.Close()
+		return
+	}
+	log.Printf("[SPECTATE] New spectator on game %s (%d watching)", g.ID, g.SpectatorCount)
+
+	conn.WriteJSON(game.WSMessage{Type: "spectate_start", Payload: map[string]interface{}{"gameId": g.ID}})
+	s.BroadcastState(g)
+	g.Unlock()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	g.Lock()
+	s.spectators.Remove(g, conn)
+	s.BroadcastState(g)
+	g.Unlock()
+}
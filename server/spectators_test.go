@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fourinrow/game"
+	"fourinrow/game/storetest"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialSpectator is the server side of a websocket whose client never reads
+func dialSpectator(t *testing.T) *wsConn {
+	t.Helper()
+	server := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		server <- ws
+	}))
+	t.Cleanup(ts.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	ws := <-server
+	t.Cleanup(func() { ws.Close() })
+	return newWSConn(ws)
+}
+
+func TestSlowSpectatorDoesNotBlockHub(t *testing.T) {
+	h := NewSpectatorHub(MaxSpectatorsPerGame)
+	slow := storetest.NewGame("alice", "bob", time.Now())
+	other := storetest.NewGame("carol", "dave", time.Now())
+	conn, watcher := dialSpectator(t), dialSpectator(t)
+	if err := h.Add(slow, conn); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// A write already in progress on the slow spectator's socket
+	conn.mu.Lock()
+	sent := make(chan struct{})
+	go func() {
+		h.Broadcast(slow.ID, game.WSMessage{Type: "update"})
+		close(sent)
+	}()
+	time.Sleep(50 * time.Millisecond) // let the broadcast reach the stuck write
+
+	joined := make(chan error, 1)
+	go func() { joined <- h.Add(other, watcher) }()
+	select {
+	case err := <-joined:
+		if err != nil {
+			t.Fatalf("Add to another game: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a slow spectator blocked joins to other games")
+	}
+
+	conn.mu.Unlock()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast never finished")
+	}
+}
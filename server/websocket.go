@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"fourinrow/analytics" // <--- Added this import
@@ -18,26 +20,71 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
+// WebSocketHandler serves /ws for players, spectators and replays
+func (s *Server) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	// Spectators don't need an identity, they just watch
+	if gameID := r.URL.Query().Get("spectate"); gameID != "" {
+		s.handleSpectator(w, r, gameID)
+		return
+	}
+	// Replays of saved games don't need one either
+	if gameID := r.URL.Query().Get("replay"); gameID != "" {
+		handleReplay(w, r, gameID)
+		return
+	}
+	if s.Draining() {
+		http.Error(w, ErrServerRestarting.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
+	// Without an explicit username the player joins as a guest. The guest
+	// cookie has to go out with the upgrade response, so resolve it first.
 	username := r.URL.Query().Get("username")
+	var header http.Header
 	if username == "" {
-		conn.Close()
+		guest, cookie, err := s.resolveGuest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		username = guest.Username
+		if cookie != nil {
+			header = http.Header{"Set-Cookie": {cookie.String()}}
+		}
+	} else if strings.HasPrefix(username, GuestPrefix) {
+		http.Error(w, "reserved username", http.StatusBadRequest)
 		return
 	}
 
-	// JOIN THE MATCHMAKER
-	GlobalMatchmaker.Join(username, conn)
+	ws, err := upgrader.Upgrade(w, r, header)
+	if err != nil {
+		return
+	}
+	// Games, timers, chat, the relay and Shutdown all write to this socket
+	conn := newWSConn(ws)
+	s.sockets.add(conn)
+	defer s.sockets.remove(conn)
+
+	// JOIN A PRIVATE ROOM, OR THE MATCHMAKER
+	room := strings.ToUpper(r.URL.Query().Get("room"))
+	if room != "" {
+		if err := s.rooms.Join(room, username, conn); err != nil {
+			conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+			conn.Close()
+			return
+		}
+	} else {
+		s.joinMatchmaking(username, conn)
+	}
 
 	// Read Loop
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			handleDisconnect(username)
+			if room != "" {
+				s.rooms.Leave(room, username, conn)
+			}
+			s.routeDisconnect(username, conn)
 			break
 		}
 
@@ -45,65 +92,177 @@ func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
+		s.routeClientMessage(username, conn, msg)
+	}
+}
+
+// dispatchClientMessage handles one message from a player. conn is where
+// replies go: the player's socket, or a relay when it lives on another node.
+func (s *Server) dispatchClientMessage(username string, conn game.Conn, msg game.WSMessage) {
+	if msg.Type == "leave_queue" {
+		if s.matchmaker.Leave(username, conn) {
+			conn.WriteJSON(game.WSMessage{Type: "left_queue", Payload: nil})
+		}
+		return
+	}
+
+	if msg.Type == "rematch_offer" || msg.Type == "rematch_accept" {
+		if s.Draining() {
+			conn.WriteJSON(game.WSMessage{Type: "server_restarting", Payload: ErrServerRestarting.Error()})
+			return
+		}
+		handle := s.HandleRematchOffer
+		if msg.Type == "rematch_accept" {
+			handle = s.HandleRematchAccept
+		}
+		if err := handle(username); err != nil {
+			conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		}
+		return
+	}
+
+	if msg.Type == "chat" || msg.Type == "emote" || msg.Type == "mute" || msg.Type == "block" {
+		if err := s.chat.handleMessage(username, msg); err != nil {
+			conn.WriteJSON(game.WSMessage{Type: "error", Payload: err.Error()})
+		}
+		return
+	}
 
-		if msg.Type == "move" {
-			g := game.Store.FindGameByPlayerName(username)
-			if g != nil {
-				payload := msg.Payload.(map[string]interface{})
-				col := int(payload["column"].(float64))
-				// Call the MATCHMAKER'S HandleMove
-				HandleMove(g, username, col)
+	if msg.Type == "move" {
+		g := game.FindGameByPlayerName(s.store, username)
+		if g != nil {
+			payload, _ := msg.Payload.(map[string]interface{})
+			col, ok := payload["column"].(float64)
+			if !ok {
+				conn.WriteJSON(game.WSMessage{Type: "error", Payload: "invalid column"})
+				return
 			}
+			// Call the MATCHMAKER'S HandleMove
+			g.Lock()
+			s.matchmaker.HandleMove(g, username, int(col))
+			g.Unlock()
 		}
 	}
 }
 
-func handleDisconnect(username string) {
-	g := game.Store.FindGameByPlayerName(username)
+func (s *Server) handleDisconnect(username string, conn game.Conn) {
+	// A player who drops while still queued must not be handed a bot game later
+	if s.matchmaker.Leave(username, conn) {
+		return
+	}
+
+	g := game.FindGameByPlayerName(s.store, username)
 	if g == nil || g.Status == "finished" {
+		// Still mark them gone from their last game so nobody offers them a rematch
+		if last := game.FindLatestGameByPlayerName(s.store, username); last != nil {
+			last.Lock()
+			if p := last.Players[username]; p.Conn == conn {
+				p.IsConnected = false
+			}
+			last.Unlock()
+		}
 		return
 	}
 
+	if s.hostedElsewhere(g) {
+		return // the host node hears about it too
+	}
+
+	g.Lock()
+	defer g.Unlock()
 	player := g.Players[username]
+	if g.Status != "playing" {
+		return // ended while we were looking it up
+	}
 	player.IsConnected = false
 	
 	// FIX 1: Broadcast immediately so the other player knows about the disconnection
-	BroadcastState(g)
-
-	player.DisconnectTimer = time.AfterFunc(30*time.Second, func() {
-		if !player.IsConnected {
-			g.Status = "finished"
-
-			// FIX 2: Set the Real Winner ID instead of generic "opponent"
-			// Find the player who is NOT the one that disconnected
-			for _, p := range g.Players {
-				if p.Username != username {
-					g.Winner = p.ID
-					break
-				}
-			}
+	s.BroadcastState(g)
+
+	// Sockets close as part of a shutdown; the game is parked, not forfeited
+	if s.Draining() {
+		return
+	}
+	s.startForfeitTimer(g, player, s.cfg.DisconnectTimeout.Duration)
+}
 
-			BroadcastState(g)
-			HandleGameOver(g)
+// startForfeitTimer ends the game in the opponent's favour unless player
+// reconnects within d. Callers hold g's lock.
+func (s *Server) startForfeitTimer(g *game.Game, player *game.Player, d time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		g.Lock()
+		defer g.Unlock()
+		// A reconnect (and maybe another drop) since we were armed replaces the timer
+		if player.DisconnectTimer == timer && !player.IsConnected && g.Status == "playing" {
+			s.forfeit(g, player.Username)
 		}
 	})
+	player.DisconnectTimer = timer
 }
 
-func BroadcastState(g *game.Game) {
+// forfeit ends the game as lost by username for not reconnecting. Callers
+// hold g's lock.
+func (s *Server) forfeit(g *game.Game, username string) {
+	g.Status = "finished"
+	g.EndReason = game.EndDisconnect
+
+	// FIX 2: Set the Real Winner ID instead of generic "opponent"
+	// Find the player who is NOT the one that disconnected
+	for _, p := range g.Players {
+		if p.Username != username {
+			g.Winner = p.ID
+			break
+		}
+	}
+
+	s.HandleGameOver(g)
+}
+
+// BroadcastState sends g to its players and spectators. Callers hold g's lock.
+func (s *Server) BroadcastState(g *game.Game) {
 	for _, p := range g.Players {
 		if p.IsConnected && !p.IsBot {
 			p.Conn.WriteJSON(game.WSMessage{Type: "update", Payload: g})
 		}
 	}
+	s.spectators.Broadcast(g.ID, game.WSMessage{Type: "update", Payload: g})
 }
 
-func HandleGameOver(g *game.Game) {
-	// 1. Save to Database
+// HandleGameOver persists the result, updates ratings and sends the final
+// "update" (including rating deltas) to both players. Callers hold g's lock.
+func (s *Server) HandleGameOver(g *game.Game) {
+	if !g.MarkEnded() {
+		return
+	}
+	stopClock(g)
+	if g.FinishedAt.IsZero() {
+		g.FinishedAt = time.Now()
+	}
+
+	if g.Series != nil {
+		g.Series.Record(g)
+	}
+
+	// 1. Save to Database (ratings and the game_finished event for the
+	// outbox relay are written in the same transaction)
+	saved := false
 	if db.Repo != nil {
-		db.Repo.SaveGame(g)
+		changes, err := db.Repo.SaveGame(g)
+		if err != nil {
+			log.Printf("[DB ERROR] Failed to save game %s: %v", g.ID, err)
+		}
+		g.RatingChanges = changes
+		saved = err == nil
 	}
+	game.Save(s.store, g)
+	s.BroadcastState(g)
+	s.spectators.Close(g.ID)
 
-	// 2. Send "Game Over" Event to Kafka (Analytics)
+	if saved {
+		return
+	}
+	// 2. The event never reached the outbox; send "Game Over" straight to Kafka
 	// We send the Winner's name/ID so the consumer can count wins & duration
 	analytics.Producer.Emit(analytics.GameEvent{
 		Type:      "game_finished",